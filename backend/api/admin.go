@@ -0,0 +1,44 @@
+package api
+
+import (
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// metricNames are the expvar maps published on the admin listener. The
+// defaults expvar adds, cmdline and memstats, are left out since the command
+// line can carry secrets.
+var metricNames = []string{"ws", "janitor", "fan_out"}
+
+// runAdmin serves runtime metrics on the loopback-only admin address.
+func (s *APIServer) runAdmin() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/vars", handleMetrics)
+
+	log.Println("Admin listening on " + s.config.Server.AdminAddr)
+	if err := http.ListenAndServe(s.config.Server.AdminAddr, mux); err != nil {
+		log.Printf("Admin listener stopped: %v", err)
+	}
+}
+
+// handleMetrics writes the metricNames vars in the format of expvar.Handler.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	fmt.Fprint(w, "{")
+	first := true
+	for _, name := range metricNames {
+		v := expvar.Get(name)
+		if v == nil {
+			continue
+		}
+		if !first {
+			fmt.Fprint(w, ",")
+		}
+		first = false
+		fmt.Fprintf(w, "\n%q: %s", name, v)
+	}
+	fmt.Fprint(w, "\n}\n")
+}
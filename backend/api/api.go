@@ -3,7 +3,11 @@ package api
 import (
 	"log"
 	"net/http"
+	"net/netip"
 
+	"github.com/carson2222/social-app/config"
+	"github.com/carson2222/social-app/mailer"
+	"github.com/carson2222/social-app/ratelimit"
 	"github.com/carson2222/social-app/storage"
 	"github.com/carson2222/social-app/ws"
 	"github.com/gorilla/handlers"
@@ -11,16 +15,26 @@ import (
 )
 
 type APIServer struct {
-	listenAddr string
-	storage    *storage.PostgresStore
-	wsServer   *ws.WebSocketServer
+	config   *config.Config
+	storage  storage.Storage
+	wsServer *ws.WebSocketServer
+	limiter  *ratelimit.Limiter
+	mailer   mailer.Mailer
+	// Peers whose X-Forwarded-For header is trusted
+	trustedProxies []netip.Prefix
 }
 
-func NewAPIServer(listenAddr string, storage *storage.PostgresStore, wsServer *ws.WebSocketServer) *APIServer {
+func NewAPIServer(cfg *config.Config, storage storage.Storage, wsServer *ws.WebSocketServer, mailer mailer.Mailer) *APIServer {
+	// Already checked by cfg.Validate
+	trustedProxies, _ := cfg.Server.ProxyPrefixes()
+
 	return &APIServer{
-		listenAddr: listenAddr,
-		storage:    storage,
-		wsServer:   wsServer,
+		config:         cfg,
+		storage:        storage,
+		wsServer:       wsServer,
+		limiter:        ratelimit.New(cfg.RateLimit, storage),
+		mailer:         mailer,
+		trustedProxies: trustedProxies,
 	}
 }
 
@@ -33,18 +47,41 @@ func (s *APIServer) Run() {
 	router.HandleFunc("/auth/login", s.handleLogin)
 	router.HandleFunc("/auth/register", s.handleRegister)
 	router.HandleFunc("/auth/logout", s.handleLogout)
+	router.HandleFunc("/auth/password", s.handleChangePassword).Methods("POST")
+	router.HandleFunc("/auth/forgot", s.handleForgotPassword).Methods("POST")
+	router.HandleFunc("/auth/reset", s.handleResetPassword).Methods("POST")
+
+	router.HandleFunc("/sessions", s.handleSessions).Methods("GET")
+	router.HandleFunc("/sessions/revoke-others", s.handleRevokeOtherSessions).Methods("POST")
+	router.HandleFunc("/sessions/{id}", s.handleRevokeSession).Methods("DELETE")
 
 	router.HandleFunc("/profile", s.handleProfile).Methods("POST")
 	router.HandleFunc("/profile/{id}", s.handleProfile).Methods("GET")
 
+	router.HandleFunc("/blocks", s.handleBlocks).Methods("GET")
+
+	router.HandleFunc("/chats", s.handleChats).Methods("GET")
+	router.HandleFunc("/chats/direct", s.handleDirectChat).Methods("POST")
+	router.HandleFunc("/chats/{id}/messages", s.handleChatMessages).Methods("GET")
+	router.HandleFunc("/chats/{id}/reads", s.handleChatReads).Methods("GET")
+	router.HandleFunc("/messages/{id}/edits", s.handleMessageEdits).Methods("GET")
+	router.HandleFunc("/messages/{id}/thread", s.handleMessageThread).Methods("GET")
+
+	router.HandleFunc("/attachments", s.handleUploadAttachment).Methods("POST")
+	router.HandleFunc("/attachments/{id}", s.handleAttachment).Methods("GET")
+
 	// router.HandleFunc("/friends/{action}/{id}", s.handleAddFriend).Methods("POST")
 
 	// Serve static files
-	router.PathPrefix("/uploads/").Handler(http.StripPrefix("/uploads/", http.FileServer(http.Dir("./uploads/"))))
+	router.PathPrefix("/uploads/").Handler(http.StripPrefix("/uploads/", http.FileServer(http.Dir(s.config.Uploads.Dir))))
 
 	// CORS settings
 	allowCredentials := handlers.AllowCredentials()
 
-	log.Println("Listening on port " + s.listenAddr)
-	http.ListenAndServe(s.listenAddr, handlers.CORS(allowCredentials)(router))
+	if s.config.Server.AdminAddr != "" {
+		go s.runAdmin()
+	}
+
+	log.Println("Listening on port " + s.config.Server.ListenAddr)
+	http.ListenAndServe(s.config.Server.ListenAddr, handlers.CORS(allowCredentials)(router))
 }
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"errors"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/carson2222/social-app/storage"
+	"github.com/carson2222/social-app/types"
+	"github.com/carson2222/social-app/utils"
+	"github.com/gorilla/mux"
+)
+
+// handleUploadAttachment stores the multipart "file" field and returns the
+// attachment, which can then be sent with a newMessage.
+func (s *APIServer) handleUploadAttachment(w http.ResponseWriter, r *http.Request) {
+	// Verify session
+	userId, _, err := s.authSession(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusUnauthorized, "Unauthorized:"+err.Error())
+		return
+	}
+
+	// Leave room for the multipart headers around the file
+	cfg := s.config.Attachments
+	r.Body = http.MaxBytesReader(w, r.Body, cfg.MaxSize+1<<20)
+
+	upload, err := utils.SaveUpload(r, "file", cfg.Dir, cfg.MaxSize, cfg.AllowedTypes)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, "Failed to upload attachment:"+err.Error())
+		return
+	}
+
+	attachment := types.Attachment{
+		UploaderID:   userId,
+		Path:         upload.Path,
+		OriginalName: upload.OriginalName,
+		MimeType:     upload.MimeType,
+		Size:         upload.Size,
+		CreatedAt:    time.Now(),
+	}
+	attachment.Width, attachment.Height = imageSize(upload.Path, upload.MimeType)
+
+	attachment.ID, err = s.storage.CreateAttachment(attachment)
+	if err != nil {
+		os.Remove(upload.Path)
+		utils.WriteJSON(w, http.StatusInternalServerError, "Failed to save attachment:"+err.Error())
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, attachment)
+}
+
+// imageSize returns the dimensions of an image file, or zeros for other files
+// and image formats that can not be decoded.
+func imageSize(path, mimeType string) (int, int) {
+	if !strings.HasPrefix(mimeType, "image/") {
+		return 0, 0
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0
+	}
+	defer f.Close()
+
+	config, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return 0, 0
+	}
+
+	return config.Width, config.Height
+}
+
+// handleAttachment downloads an attachment. Sent attachments are available to
+// the members of their chat, unsent ones only to the uploader.
+func (s *APIServer) handleAttachment(w http.ResponseWriter, r *http.Request) {
+	// Verify session
+	userId, _, err := s.authSession(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusUnauthorized, "Unauthorized:"+err.Error())
+		return
+	}
+
+	attachmentId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, "Invalid attachment id")
+		return
+	}
+
+	attachment, err := s.storage.GetAttachment(attachmentId)
+	if errors.Is(err, storage.ErrAttachmentNotFound) || (err == nil && attachment.MessageID == 0 && attachment.UploaderID != userId) {
+		utils.WriteJSON(w, http.StatusNotFound, "Attachment not found")
+		return
+	}
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, "Failed to get attachment:"+err.Error())
+		return
+	}
+
+	if attachment.MessageID != 0 && !s.requireChatMember(w, userId, attachment.ChatID) {
+		return
+	}
+
+	f, err := os.Open(attachment.Path)
+	if err != nil {
+		log.Printf("Error opening attachment %d: %v", attachment.ID, err)
+		utils.WriteJSON(w, http.StatusNotFound, "Attachment not found")
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, "Failed to read attachment:"+err.Error())
+		return
+	}
+
+	// Never let browsers render attachments as pages of this site
+	w.Header().Set("Content-Type", attachment.MimeType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.OriginalName}))
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
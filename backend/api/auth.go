@@ -5,8 +5,14 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"math"
+	"net"
 	"net/http"
 	"net/mail"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/carson2222/social-app/types"
 	"github.com/carson2222/social-app/utils"
@@ -26,14 +32,26 @@ func (s *APIServer) handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	ip := s.clientIP(r)
+	if s.throttled(w, ip, credentials.Email) {
+		return
+	}
+
 	userId, err := s.storage.AuthUser(credentials)
 	if err != nil || userId == -1 {
 		log.Println(err)
+		if err := s.limiter.Fail(ip, credentials.Email); err != nil {
+			log.Println(err)
+		}
 		utils.WriteJSON(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	sessionId, err := s.storage.CreateSession(userId)
+	if err := s.limiter.Succeed(credentials.Email); err != nil {
+		log.Println(err)
+	}
+
+	sessionId, err := s.storage.CreateSession(userId, r.UserAgent(), s.clientIP(r))
 	if err != nil || sessionId == "" {
 		log.Println(err)
 		utils.WriteJSON(w, http.StatusInternalServerError, err.Error())
@@ -58,9 +76,17 @@ func (s *APIServer) handleRegister(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	ip := s.clientIP(r)
+	if s.throttled(w, ip, credentials.Email) {
+		return
+	}
+
 	userId, err := s.storage.CreateUser(credentials)
 	if err != nil || userId == -1 {
 		log.Println(err)
+		if err := s.limiter.Fail(ip, credentials.Email); err != nil {
+			log.Println(err)
+		}
 		utils.WriteJSON(w, http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -71,7 +97,7 @@ func (s *APIServer) handleRegister(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	sessionId, err := s.storage.CreateSession(userId)
+	sessionId, err := s.storage.CreateSession(userId, r.UserAgent(), s.clientIP(r))
 	if err != nil || sessionId == "" {
 		log.Println(err)
 		utils.WriteJSON(w, http.StatusInternalServerError, err.Error())
@@ -90,14 +116,14 @@ func (s *APIServer) handleLogout(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	sessionId, err := s.getSession(r)
-	if err != nil || sessionId == "" {
+	userId, session, err := s.authSession(r)
+	if err != nil {
 		utils.WriteJSON(w, http.StatusUnauthorized, "Unauthorized"+err.Error())
 		return
 	}
 
-	isValid, _, err := s.storage.VerifySession(sessionId)
-	if err != nil || !isValid {
+	sessionId, err := s.getSession(r)
+	if err != nil {
 		utils.WriteJSON(w, http.StatusUnauthorized, "Unauthorized"+err.Error())
 		return
 	}
@@ -108,6 +134,11 @@ func (s *APIServer) handleLogout(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Close the WebSocket connections of this session
+	if err := s.wsServer.RevokeSessions(userId, []int{session.ID}); err != nil {
+		log.Println(err)
+	}
+
 	utils.WriteJSON(w, http.StatusOK, "OK")
 }
 
@@ -124,33 +155,41 @@ func (s *APIServer) createCredentials(r *http.Request) (*types.Credentials, erro
 		return nil, err
 	}
 
-	if credentials.Password == "" {
-		return nil, errors.New("password is empty")
+	if err := validatePassword(credentials.Password); err != nil {
+		return nil, err
 	}
 
-	if len(credentials.Password) < 8 {
-		return nil, errors.New("password must be at least 8 characters long")
+	return credentials, nil
+}
+
+func validatePassword(password string) error {
+	if password == "" {
+		return errors.New("password is empty")
 	}
 
-	if len(credentials.Password) > 50 {
-		return nil, errors.New("password must be at most 50 characters long")
+	if len(password) < 8 {
+		return errors.New("password must be at least 8 characters long")
 	}
 
-	return credentials, nil
+	if len(password) > 50 {
+		return errors.New("password must be at most 50 characters long")
+	}
+
+	return nil
 }
-func (s *APIServer) authSession(r *http.Request) (int, string, error) {
+func (s *APIServer) authSession(r *http.Request) (int, types.Session, error) {
 
 	sessionId, err := s.getSession(r)
 	if err != nil || sessionId == "" {
-		return -1, "", fmt.Errorf("failed to get session: %w", err)
+		return -1, types.Session{}, fmt.Errorf("failed to get session: %w", err)
 	}
 
-	isValid, userId, err := s.storage.VerifySession(sessionId)
-	if err != nil || userId == -1 || !isValid {
-		return -1, "", fmt.Errorf("failed to verify session: %w", err)
+	session, err := s.storage.VerifySession(sessionId)
+	if err != nil {
+		return -1, types.Session{}, fmt.Errorf("failed to verify session: %w", err)
 	}
 
-	return userId, sessionId, err
+	return session.UserID, session, nil
 }
 
 func (s *APIServer) getSession(r *http.Request) (string, error) {
@@ -163,3 +202,77 @@ func (s *APIServer) getSession(r *http.Request) (string, error) {
 
 	return cookie.Value, nil
 }
+
+// throttled writes a 429 response and reports true if the client must wait
+// before it may try to authenticate as email again.
+func (s *APIServer) throttled(w http.ResponseWriter, ip, email string) bool {
+	wait, err := s.limiter.Check(ip, email)
+	return s.mustWait(w, wait, err)
+}
+
+// mustWait writes a 429 response and reports true if wait is positive, or a
+// 500 response if the rate limit could not be checked.
+func (s *APIServer) mustWait(w http.ResponseWriter, wait time.Duration, err error) bool {
+	if err != nil {
+		log.Println(err)
+		utils.WriteJSON(w, http.StatusInternalServerError, "Failed to check rate limit:"+err.Error())
+		return true
+	}
+
+	if wait <= 0 {
+		return false
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	utils.WriteJSON(w, http.StatusTooManyRequests, "Too many attempts, try again later")
+	return true
+}
+
+// clientIP returns the address the request came from, without the port. If
+// the peer is a trusted proxy, X-Forwarded-For is walked from the right and the
+// first address that isn't a trusted proxy is used, since everything left of
+// it could have been made up by the client.
+func (s *APIServer) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	if !s.isTrustedProxy(host) {
+		return host
+	}
+
+	var forwarded []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, addr := range strings.Split(header, ",") {
+			forwarded = append(forwarded, strings.TrimSpace(addr))
+		}
+	}
+
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		if _, err := netip.ParseAddr(forwarded[i]); err != nil {
+			break
+		}
+		host = forwarded[i]
+		if !s.isTrustedProxy(host) {
+			break
+		}
+	}
+
+	return host
+}
+
+func (s *APIServer) isTrustedProxy(host string) bool {
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+
+	for _, prefix := range s.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/carson2222/social-app/config"
+	"github.com/carson2222/social-app/ratelimit"
+	"github.com/carson2222/social-app/storage"
+)
+
+func TestThrottledRetryAfter(t *testing.T) {
+	cfg := config.Default()
+	cfg.RateLimit.FreeAttempts = 1
+	cfg.RateLimit.BaseDelay = 1500 * time.Millisecond
+	cfg.RateLimit.EmailLockout = 2
+
+	s := &APIServer{config: cfg}
+	s.limiter = ratelimit.New(cfg.RateLimit, storage.NewMemoryStorage(cfg))
+
+	tests := []struct {
+		name       string
+		failures   int
+		throttled  bool
+		retryAfter string
+	}{
+		{"free attempt", 0, false, ""},
+		{"delay rounded up", 1, true, "2"},
+		{"lockout", 2, true, "900"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			email := tt.name + "@example.com"
+			for range tt.failures {
+				if err := s.limiter.Fail("1.2.3.4", email); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			w := httptest.NewRecorder()
+			if got := s.throttled(w, "5.6.7.8", email); got != tt.throttled {
+				t.Fatalf("throttled() = %v, want %v", got, tt.throttled)
+			}
+			if got := w.Header().Get("Retry-After"); got != tt.retryAfter {
+				t.Errorf("Retry-After = %q, want %q", got, tt.retryAfter)
+			}
+			if tt.throttled && w.Code != 429 {
+				t.Errorf("status = %d, want 429", w.Code)
+			}
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	cfg := config.Default()
+	cfg.Server.TrustedProxies = []string{"10.0.0.1", "192.168.0.0/16"}
+	trustedProxies, err := cfg.Server.ProxyPrefixes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &APIServer{config: cfg, trustedProxies: trustedProxies}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"direct", "1.2.3.4:5000", nil, "1.2.3.4"},
+		{"untrusted peer ignores header", "1.2.3.4:5000", []string{"5.6.7.8"}, "1.2.3.4"},
+		{"trusted peer without header", "10.0.0.1:5000", nil, "10.0.0.1"},
+		{"trusted peer", "10.0.0.1:5000", []string{"5.6.7.8"}, "5.6.7.8"},
+		{"spoofed entries left of client", "10.0.0.1:5000", []string{"9.9.9.9, 5.6.7.8"}, "5.6.7.8"},
+		{"chain of trusted proxies", "10.0.0.1:5000", []string{"5.6.7.8, 192.168.1.2"}, "5.6.7.8"},
+		{"several headers", "10.0.0.1:5000", []string{"9.9.9.9", "5.6.7.8, 192.168.1.2"}, "5.6.7.8"},
+		{"invalid entry stops the walk", "10.0.0.1:5000", []string{"5.6.7.8, junk, 192.168.1.2"}, "192.168.1.2"},
+		{"ipv4 mapped peer", "[::ffff:10.0.0.1]:5000", []string{"5.6.7.8"}, "5.6.7.8"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, header := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", header)
+			}
+
+			if got := s.clientIP(r); got != tt.want {
+				t.Errorf("clientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/carson2222/social-app/utils"
+)
+
+// handleBlocks lists the users the requester blocked, oldest first.
+func (s *APIServer) handleBlocks(w http.ResponseWriter, r *http.Request) {
+	// Verify session
+	userId, _, err := s.authSession(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusUnauthorized, "Unauthorized:"+err.Error())
+		return
+	}
+
+	blocked, err := s.storage.GetBlockedUsers(userId)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, "Failed to get blocked users:"+err.Error())
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, blocked)
+}
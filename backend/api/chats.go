@@ -0,0 +1,232 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/carson2222/social-app/storage"
+	"github.com/carson2222/social-app/types"
+	"github.com/carson2222/social-app/utils"
+	"github.com/carson2222/social-app/ws"
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 100
+)
+
+func (s *APIServer) handleChats(w http.ResponseWriter, r *http.Request) {
+	// Verify session
+	userId, _, err := s.authSession(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusUnauthorized, "Unauthorized:"+err.Error())
+		return
+	}
+
+	limit, err := queryInt(r, "limit", defaultPageLimit)
+	if err != nil || limit < 1 || limit > maxPageLimit {
+		utils.WriteJSON(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxPageLimit))
+		return
+	}
+
+	beforeActivity, beforeChatId, err := parseChatCursor(r.URL.Query().Get("cursor"))
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, "Invalid cursor")
+		return
+	}
+
+	// Fetch one extra chat to know if there is a next page
+	chats, err := s.storage.GetChatsInfo(userId, beforeActivity, beforeChatId, limit+1)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, "Failed to get chats:"+err.Error())
+		return
+	}
+
+	page := types.ChatListPage{Chats: chats}
+	if len(chats) > limit {
+		page.Chats = chats[:limit]
+		last := page.Chats[limit-1]
+		page.NextCursor = formatChatCursor(last.LastActivity, last.ChatId)
+	}
+
+	utils.WriteJSON(w, http.StatusOK, page)
+}
+
+// Chat list cursors are "<last activity in unix nanoseconds>_<chat id>".
+func formatChatCursor(lastActivity time.Time, chatId int) string {
+	return fmt.Sprintf("%d_%d", lastActivity.UnixNano(), chatId)
+}
+
+func parseChatCursor(cursor string) (time.Time, int, error) {
+	if cursor == "" {
+		return time.Time{}, 0, nil
+	}
+
+	nanosStr, chatIdStr, ok := strings.Cut(cursor, "_")
+	if !ok {
+		return time.Time{}, 0, errors.New("invalid cursor")
+	}
+
+	nanos, err1 := strconv.ParseInt(nanosStr, 10, 64)
+	chatId, err2 := strconv.Atoi(chatIdStr)
+	if err1 != nil || err2 != nil || chatId < 1 {
+		return time.Time{}, 0, errors.New("invalid cursor")
+	}
+
+	return time.Unix(0, nanos).UTC(), chatId, nil
+}
+
+func (s *APIServer) handleChatMessages(w http.ResponseWriter, r *http.Request) {
+	// Verify session
+	userId, _, err := s.authSession(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusUnauthorized, "Unauthorized:"+err.Error())
+		return
+	}
+
+	chatId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, "Invalid chat id")
+		return
+	}
+
+	before, err1 := queryInt(r, "before", 0)
+	after, err2 := queryInt(r, "after", 0)
+	limit, err3 := queryInt(r, "limit", defaultPageLimit)
+	if err := errors.Join(err1, err2, err3); err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if before > 0 && after > 0 {
+		utils.WriteJSON(w, http.StatusBadRequest, "before and after can not be used together")
+		return
+	}
+
+	if limit < 1 || limit > maxPageLimit {
+		utils.WriteJSON(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxPageLimit))
+		return
+	}
+
+	if !s.requireChatMember(w, userId, chatId) {
+		return
+	}
+
+	// Fetch one extra message to know if there are more
+	messages, err := s.storage.GetChatMessages(userId, chatId, before, after, limit+1)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, "Failed to get messages:"+err.Error())
+		return
+	}
+
+	page := types.ChatMessagesPage{Messages: messages}
+	if len(messages) > limit {
+		page.HasMore = true
+		if after > 0 {
+			page.Messages = messages[:limit]
+		} else {
+			page.Messages = messages[1:]
+		}
+	}
+
+	utils.WriteJSON(w, http.StatusOK, page)
+}
+
+// handleChatReads returns every member's read cursor, so clients can show who
+// has seen each message.
+func (s *APIServer) handleChatReads(w http.ResponseWriter, r *http.Request) {
+	// Verify session
+	userId, _, err := s.authSession(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusUnauthorized, "Unauthorized:"+err.Error())
+		return
+	}
+
+	chatId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, "Invalid chat id")
+		return
+	}
+
+	if !s.requireChatMember(w, userId, chatId) {
+		return
+	}
+
+	cursors, err := s.storage.GetReadCursors(chatId)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, "Failed to get read cursors:"+err.Error())
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, cursors)
+}
+
+// handleDirectChat returns the 1:1 chat with the user in the "data" form
+// value, creating it if needed.
+func (s *APIServer) handleDirectChat(w http.ResponseWriter, r *http.Request) {
+	// Verify session
+	userId, _, err := s.authSession(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusUnauthorized, "Unauthorized:"+err.Error())
+		return
+	}
+
+	var request types.DirectChatRequest
+	if err := json.Unmarshal([]byte(r.FormValue("data")), &request); err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, "Invalid request:"+err.Error())
+		return
+	}
+
+	chat, err := s.wsServer.OpenDirectChat(userId, request.UserID)
+	if err != nil {
+		var wsErr *ws.Error
+		switch {
+		case errors.As(err, &wsErr) && wsErr.Code == ws.CodeUserNotFound:
+			utils.WriteJSON(w, http.StatusNotFound, wsErr.Message)
+		case errors.As(err, &wsErr):
+			utils.WriteJSON(w, http.StatusBadRequest, wsErr.Message)
+		default:
+			utils.WriteJSON(w, http.StatusInternalServerError, "Failed to open direct chat:"+err.Error())
+		}
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, chat)
+}
+
+// requireChatMember writes an error response and returns false unless the user
+// is a member of the chat.
+func (s *APIServer) requireChatMember(w http.ResponseWriter, userId, chatId int) bool {
+	err := s.storage.IsUserInChat(userId, chatId)
+	if errors.Is(err, storage.ErrNotInChat) {
+		utils.WriteJSON(w, http.StatusForbidden, "You are not a member of this chat")
+		return false
+	}
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, "Failed to check chat membership:"+err.Error())
+		return false
+	}
+
+	return true
+}
+
+// queryInt parses a non-negative integer query parameter.
+func queryInt(r *http.Request, name string, defaultValue int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, errors.New("invalid " + name)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/carson2222/social-app/storage"
+	"github.com/carson2222/social-app/types"
+	"github.com/carson2222/social-app/utils"
+	"github.com/gorilla/mux"
+)
+
+// handleMessageEdits returns the previous versions of an edited message.
+func (s *APIServer) handleMessageEdits(w http.ResponseWriter, r *http.Request) {
+	// Verify session
+	userId, _, err := s.authSession(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusUnauthorized, "Unauthorized:"+err.Error())
+		return
+	}
+
+	message, ok := s.requireMessage(w, r, userId)
+	if !ok {
+		return
+	}
+
+	edits, err := s.storage.GetMessageEdits(message.MessageID)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, "Failed to get message edits:"+err.Error())
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, edits)
+}
+
+// handleMessageThread returns the replies to a message.
+func (s *APIServer) handleMessageThread(w http.ResponseWriter, r *http.Request) {
+	// Verify session
+	userId, _, err := s.authSession(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusUnauthorized, "Unauthorized:"+err.Error())
+		return
+	}
+
+	message, ok := s.requireMessage(w, r, userId)
+	if !ok {
+		return
+	}
+
+	replies, err := s.storage.GetMessageReplies(userId, message.MessageID)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, "Failed to get replies:"+err.Error())
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, replies)
+}
+
+// requireMessage loads the message named by the {id} path variable. It writes
+// an error response and returns false unless the message exists and the user
+// is a member of its chat.
+func (s *APIServer) requireMessage(w http.ResponseWriter, r *http.Request, userId int) (types.ChatMessage, bool) {
+	messageId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, "Invalid message id")
+		return types.ChatMessage{}, false
+	}
+
+	message, err := s.storage.GetMessage(messageId)
+	if errors.Is(err, storage.ErrMessageNotFound) {
+		utils.WriteJSON(w, http.StatusNotFound, "Message not found")
+		return types.ChatMessage{}, false
+	}
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, "Failed to get message:"+err.Error())
+		return types.ChatMessage{}, false
+	}
+
+	if !s.requireChatMember(w, userId, message.ChatID) {
+		return types.ChatMessage{}, false
+	}
+
+	return message, true
+}
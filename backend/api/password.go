@@ -0,0 +1,191 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/carson2222/social-app/mailer"
+	"github.com/carson2222/social-app/storage"
+	"github.com/carson2222/social-app/types"
+	"github.com/carson2222/social-app/utils"
+)
+
+// handleChangePassword sets a new password after checking the current one and
+// signs every other session out.
+func (s *APIServer) handleChangePassword(w http.ResponseWriter, r *http.Request) {
+	// Verify session
+	userId, session, err := s.authSession(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusUnauthorized, "Unauthorized:"+err.Error())
+		return
+	}
+
+	request := types.ChangePasswordRequest{}
+	if err := json.Unmarshal([]byte(r.FormValue("data")), &request); err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, "Invalid data:"+err.Error())
+		return
+	}
+
+	if err := validatePassword(request.NewPassword); err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// A stolen session must not be able to guess the current password, not
+	// even from many IPs
+	ip := s.clientIP(r)
+	wait, err := s.limiter.CheckUser(ip, userId)
+	if s.mustWait(w, wait, err) {
+		return
+	}
+
+	matches, err := s.storage.CheckPassword(userId, request.CurrentPassword)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, "Failed to check password:"+err.Error())
+		return
+	}
+
+	if !matches {
+		if err := s.limiter.FailUser(ip, userId); err != nil {
+			log.Println(err)
+		}
+		utils.WriteJSON(w, http.StatusForbidden, "Current password is wrong")
+		return
+	}
+
+	if err := s.limiter.SucceedUser(userId); err != nil {
+		log.Println(err)
+	}
+
+	if err := s.storage.SetPassword(userId, request.NewPassword); err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, "Failed to change password:"+err.Error())
+		return
+	}
+
+	s.revokeSessions(userId, session.ID)
+
+	utils.WriteJSON(w, http.StatusOK, "OK")
+}
+
+// handleForgotPassword emails a reset link if the email belongs to a user. The
+// response is the same either way, so it can't be used to find accounts.
+func (s *APIServer) handleForgotPassword(w http.ResponseWriter, r *http.Request) {
+	request := types.ForgotPasswordRequest{}
+	if err := json.Unmarshal([]byte(r.FormValue("data")), &request); err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, "Invalid data:"+err.Error())
+		return
+	}
+
+	// Every request counts against the email, which keeps the endpoint from
+	// being used to flood an inbox. It has its own bucket, so it doesn't
+	// lock the owner out of logging in.
+	wait, err := s.limiter.CheckForgot(request.Email)
+	if s.mustWait(w, wait, err) {
+		return
+	}
+	if err := s.limiter.FailForgot(request.Email); err != nil {
+		log.Println(err)
+	}
+
+	// Sent in the background, so the response takes as long whether the
+	// email belongs to a user or not
+	go func() {
+		if err := s.sendPasswordReset(request.Email); err != nil {
+			log.Printf("Error sending password reset: %v", err)
+		}
+	}()
+
+	utils.WriteJSON(w, http.StatusOK, "OK")
+}
+
+func (s *APIServer) sendPasswordReset(email string) error {
+	userId, err := s.storage.GetUserIDByEmail(email)
+	if errors.Is(err, storage.ErrUserNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	token, err := storage.NewToken()
+	if err != nil {
+		return err
+	}
+
+	ttl := s.config.PasswordReset.TokenTTL
+	if err := s.storage.CreatePasswordReset(userId, storage.HashToken(token), time.Now().Add(ttl)); err != nil {
+		return err
+	}
+
+	link, err := url.Parse(s.config.PasswordReset.URL)
+	if err != nil {
+		return err
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	return s.mailer.Send(mailer.Message{
+		To:      email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Someone asked to reset the password of your account.\n\n"+
+			"Open this link within %s to choose a new password:\n%s\n\n"+
+			"If it wasn't you, you can ignore this email.\n", ttl, link),
+	})
+}
+
+// handleResetPassword sets a new password with a token from a reset email and
+// signs every session of the user out.
+func (s *APIServer) handleResetPassword(w http.ResponseWriter, r *http.Request) {
+	request := types.ResetPasswordRequest{}
+	if err := json.Unmarshal([]byte(r.FormValue("data")), &request); err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, "Invalid data:"+err.Error())
+		return
+	}
+
+	if err := validatePassword(request.Password); err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ip := s.clientIP(r)
+	if s.throttled(w, ip, "") {
+		return
+	}
+
+	userId, err := s.storage.ResetPassword(storage.HashToken(request.Token), request.Password)
+	if errors.Is(err, storage.ErrResetTokenInvalid) {
+		if err := s.limiter.Fail(ip, ""); err != nil {
+			log.Println(err)
+		}
+		utils.WriteJSON(w, http.StatusBadRequest, "Invalid or expired reset token")
+		return
+	}
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, "Failed to reset password:"+err.Error())
+		return
+	}
+
+	s.revokeSessions(userId, 0)
+
+	utils.WriteJSON(w, http.StatusOK, "OK")
+}
+
+// revokeSessions signs every session of the user out except keepSessionId and
+// closes their WebSocket connections.
+func (s *APIServer) revokeSessions(userId, keepSessionId int) {
+	revoked, err := s.storage.RevokeOtherSessions(userId, keepSessionId)
+	if err != nil {
+		log.Printf("Error revoking sessions of user %d: %v", userId, err)
+		return
+	}
+
+	if err := s.wsServer.RevokeSessions(userId, revoked); err != nil {
+		log.Println(err)
+	}
+}
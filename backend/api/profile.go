@@ -44,12 +44,24 @@ func (s *APIServer) handleProfile(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Verify session
-		_, _, err = s.authSession(r)
+		userId, _, err := s.authSession(r)
 		if err != nil {
 			utils.WriteJSON(w, http.StatusInternalServerError, "Unauthorized:"+err.Error())
 			return
 		}
 
+		// Users who were blocked can't see the blocker's profile
+		blocked, err := s.storage.HasBlocked(id, userId)
+		if err != nil {
+			utils.WriteJSON(w, http.StatusInternalServerError, "Failed to get profile:"+err.Error())
+			return
+		}
+
+		if blocked {
+			utils.WriteJSON(w, http.StatusNotFound, "Profile not found")
+			return
+		}
+
 		// Get profile
 		profile, err := s.storage.GetProfileByID(id)
 		if err != nil {
@@ -76,7 +88,7 @@ func (s *APIServer) updateProfile(r *http.Request, userId int) error {
 	// Load pfp if added
 	pfpSrc := ""
 	if data.Pfp {
-		pfpSrc, err = utils.UploadProfilePicture(r)
+		pfpSrc, err = utils.UploadProfilePicture(r, s.config.Uploads.ProfilePictureDir(), s.config.Uploads.MaxProfilePictureSize)
 		if err != nil {
 			log.Println(err)
 			return fmt.Errorf("failed to upload profile picture: %w", err)
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/carson2222/social-app/utils"
+	"github.com/gorilla/mux"
+)
+
+// handleSessions lists the requester's active sessions, most recently used first.
+func (s *APIServer) handleSessions(w http.ResponseWriter, r *http.Request) {
+	// Verify session
+	userId, current, err := s.authSession(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusUnauthorized, "Unauthorized:"+err.Error())
+		return
+	}
+
+	sessions, err := s.storage.GetSessions(userId)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, "Failed to get sessions:"+err.Error())
+		return
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == current.ID
+	}
+
+	utils.WriteJSON(w, http.StatusOK, sessions)
+}
+
+// handleRevokeSession signs one of the requester's sessions out.
+func (s *APIServer) handleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	sessionId, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, "Invalid id")
+		return
+	}
+
+	// Verify session
+	userId, _, err := s.authSession(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusUnauthorized, "Unauthorized:"+err.Error())
+		return
+	}
+
+	revoked, err := s.storage.RevokeSession(userId, sessionId)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, "Failed to revoke session:"+err.Error())
+		return
+	}
+
+	if !revoked {
+		utils.WriteJSON(w, http.StatusNotFound, "Session not found")
+		return
+	}
+
+	if err := s.wsServer.RevokeSessions(userId, []int{sessionId}); err != nil {
+		log.Println(err)
+	}
+
+	utils.WriteJSON(w, http.StatusOK, "OK")
+}
+
+// handleRevokeOtherSessions signs every session out except the current one.
+func (s *APIServer) handleRevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	// Verify session
+	userId, current, err := s.authSession(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusUnauthorized, "Unauthorized:"+err.Error())
+		return
+	}
+
+	revoked, err := s.storage.RevokeOtherSessions(userId, current.ID)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, "Failed to revoke sessions:"+err.Error())
+		return
+	}
+
+	if err := s.wsServer.RevokeSessions(userId, revoked); err != nil {
+		log.Println(err)
+	}
+
+	utils.WriteJSON(w, http.StatusOK, revoked)
+}
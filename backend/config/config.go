@@ -0,0 +1,412 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/netip"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Config holds every setting the server needs. Values are resolved from, in
+// increasing order of precedence: defaults, the JSON config file, SOCIAL_*
+// environment variables and command line flags. See Load.
+type Config struct {
+	Server        ServerConfig        `json:"server"`
+	Database      DatabaseConfig      `json:"database"`
+	Session       SessionConfig       `json:"session"`
+	Uploads       UploadsConfig       `json:"uploads"`
+	Attachments   AttachmentsConfig   `json:"attachments"`
+	WebSocket     WebSocketConfig     `json:"websocket"`
+	EventLog      EventLogConfig      `json:"event_log"`
+	Reactions     ReactionsConfig     `json:"reactions"`
+	Janitor       JanitorConfig       `json:"janitor"`
+	RateLimit     RateLimitConfig     `json:"rate_limit"`
+	Mail          MailConfig          `json:"mail"`
+	PasswordReset PasswordResetConfig `json:"password_reset"`
+}
+
+type ServerConfig struct {
+	ListenAddr     string   `json:"listen_addr" usage:"address the HTTP server listens on"`
+	AllowedOrigins []string `json:"allowed_origins" usage:"comma separated hosts allowed to open WebSocket connections"`
+	// The admin listener serves runtime metrics and must stay on a loopback address
+	AdminAddr string `json:"admin_addr" usage:"loopback address serving /debug/vars, empty disables it"`
+	// X-Forwarded-For is only read from requests whose peer is one of these
+	TrustedProxies []string `json:"trusted_proxies" usage:"comma separated IPs or CIDRs of reverse proxies whose X-Forwarded-For is trusted"`
+}
+
+type DatabaseConfig struct {
+	Driver      string `json:"driver" usage:"storage backend: postgres or memory"`
+	Host        string `json:"host" usage:"database host"`
+	Port        int    `json:"port" usage:"database port"`
+	User        string `json:"user" usage:"database user"`
+	Password    string `json:"password" usage:"database password"`
+	Name        string `json:"name" usage:"database name"`
+	SSLMode     string `json:"ssl_mode" usage:"database sslmode"`
+	AutoMigrate bool   `json:"auto_migrate" usage:"apply pending migrations on startup"`
+}
+
+type SessionConfig struct {
+	Duration    time.Duration `json:"duration" usage:"how long a new session stays valid"`
+	Sliding     bool          `json:"sliding" usage:"extend a session to session.duration past its last activity"`
+	MaxLifetime time.Duration `json:"max_lifetime" usage:"absolute limit on a sliding session's age, 0 for none"`
+	// Requests within TouchInterval of the last write don't update the session row
+	TouchInterval time.Duration `json:"touch_interval" usage:"how often a session's last activity and sliding expiry are written at most"`
+}
+
+type UploadsConfig struct {
+	Dir                   string `json:"dir" usage:"directory served under /uploads/"`
+	MaxProfilePictureSize int64  `json:"max_profile_picture_size" usage:"maximum profile picture size in bytes"`
+}
+
+// AttachmentsConfig covers files sent in messages. Unlike uploads they are only
+// served to chat members, so Dir must not be inside the public uploads dir.
+type AttachmentsConfig struct {
+	Dir          string   `json:"dir" usage:"directory attachments are stored in, must not be publicly served"`
+	MaxSize      int64    `json:"max_size" usage:"maximum attachment size in bytes"`
+	AllowedTypes []string `json:"allowed_types" usage:"comma separated MIME types accepted as attachments"`
+}
+
+type WebSocketConfig struct {
+	PingInterval   time.Duration `json:"ping_interval" usage:"how often the server pings each WebSocket client"`
+	PongWait       time.Duration `json:"pong_wait" usage:"how long a WebSocket client may stay silent before it is dropped"`
+	WriteWait      time.Duration `json:"write_wait" usage:"deadline for writing a single WebSocket frame"`
+	MaxMessageSize int64         `json:"max_message_size" usage:"maximum size of an incoming WebSocket message in bytes"`
+	SendQueueSize  int           `json:"send_queue_size" usage:"outgoing frames buffered per WebSocket client"`
+	OverflowPolicy string        `json:"overflow_policy" usage:"what to do when a client queue is full: drop_oldest, disconnect or coalesce"`
+	CoalesceTypes  []string      `json:"coalesce_types" usage:"comma separated event types whose queued frames may be replaced by newer ones"`
+	FanOut         string        `json:"fan_out" usage:"how events reach other instances: local (single instance) or postgres"`
+	FanOutChannel  string        `json:"fan_out_channel" usage:"postgres NOTIFY channel used by the postgres fan-out"`
+}
+
+type EventLogConfig struct {
+	Retention     time.Duration `json:"retention" usage:"how long broadcast events are kept for replay"`
+	MaxReplay     int           `json:"max_replay" usage:"most events replayed on reconnect before asking the client to resync"`
+	PruneInterval time.Duration `json:"prune_interval" usage:"how often expired events are deleted"`
+}
+
+type ReactionsConfig struct {
+	Allowed     []string `json:"allowed" usage:"comma separated reactions users may add, empty allows any"`
+	MaxDistinct int      `json:"max_distinct" usage:"most distinct reactions on a single message, 0 for no limit"`
+}
+
+// JanitorConfig schedules the background cleanup tasks. An interval of 0
+// disables its task. The event log is pruned on event_log.prune_interval.
+type JanitorConfig struct {
+	SessionsInterval       time.Duration `json:"sessions_interval" usage:"how often expired and signed out sessions are deleted, 0 disables"`
+	SessionRetention       time.Duration `json:"session_retention" usage:"how long expired and signed out sessions are kept"`
+	FriendRequestsInterval time.Duration `json:"friend_requests_interval" usage:"how often old friend requests are deleted, 0 disables"`
+	FriendRequestTTL       time.Duration `json:"friend_request_ttl" usage:"how long a friend request stays pending"`
+	AuthAttemptsInterval   time.Duration `json:"auth_attempts_interval" usage:"how often failed auth attempts older than rate_limit.window are deleted, 0 disables"`
+	FilesInterval          time.Duration `json:"files_interval" usage:"how often unreferenced upload and attachment files are deleted, 0 disables"`
+	UnsentAttachmentTTL    time.Duration `json:"unsent_attachment_ttl" usage:"how long an uploaded attachment may stay unsent"`
+	FileGracePeriod        time.Duration `json:"file_grace_period" usage:"minimum age of an unreferenced file before it is deleted"`
+}
+
+// RateLimitConfig throttles login and register attempts. Failures within the
+// window are counted per client IP and per email. After free_attempts
+// failures each attempt must wait base_delay, doubling with every failure up
+// to max_delay, and at a lockout threshold the key is locked for
+// lockout_duration after its last failure.
+type RateLimitConfig struct {
+	Enabled         bool          `json:"enabled" usage:"throttle failed login and register attempts"`
+	Window          time.Duration `json:"window" usage:"how long a failed attempt counts"`
+	FreeAttempts    int           `json:"free_attempts" usage:"failures allowed before attempts are delayed"`
+	BaseDelay       time.Duration `json:"base_delay" usage:"delay after the first throttled failure, doubled with every further failure"`
+	MaxDelay        time.Duration `json:"max_delay" usage:"longest delay between attempts before a lockout"`
+	EmailLockout    int           `json:"email_lockout" usage:"failures on an email that lock it"`
+	IPLockout       int           `json:"ip_lockout" usage:"failures from a client IP that lock it"`
+	LockoutDuration time.Duration `json:"lockout_duration" usage:"how long a locked email or IP must wait after its last failure"`
+}
+
+// MailConfig selects how emails are sent: through an SMTP server, as files in
+// an outbox directory, or kept in memory.
+type MailConfig struct {
+	Driver       string `json:"driver" usage:"how emails are sent: smtp, file or memory"`
+	From         string `json:"from" usage:"sender address of outgoing emails"`
+	SMTPHost     string `json:"smtp_host" usage:"SMTP server host"`
+	SMTPPort     int    `json:"smtp_port" usage:"SMTP server port"`
+	SMTPUser     string `json:"smtp_user" usage:"SMTP user, empty to send without authentication"`
+	SMTPPassword string `json:"smtp_password" usage:"SMTP password"`
+	OutboxDir    string `json:"outbox_dir" usage:"directory emails are written to by the file driver"`
+}
+
+type PasswordResetConfig struct {
+	URL      string        `json:"url" usage:"page linked in reset emails, the token is added as the token query parameter"`
+	TokenTTL time.Duration `json:"token_ttl" usage:"how long a password reset link stays valid"`
+}
+
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			ListenAddr:     "127.0.0.1:3000",
+			AllowedOrigins: []string{"localhost:3000"},
+			AdminAddr:      "127.0.0.1:3001",
+		},
+		Database: DatabaseConfig{
+			Driver:      "postgres",
+			Host:        "localhost",
+			Port:        5432,
+			User:        "admin",
+			Password:    "admin",
+			Name:        "postgres",
+			SSLMode:     "disable",
+			AutoMigrate: true,
+		},
+		Session: SessionConfig{
+			Duration:      24 * time.Hour,
+			MaxLifetime:   30 * 24 * time.Hour,
+			TouchInterval: time.Minute,
+		},
+		Uploads: UploadsConfig{
+			Dir:                   "./uploads",
+			MaxProfilePictureSize: 10 << 20,
+		},
+		Attachments: AttachmentsConfig{
+			Dir:     "./attachments",
+			MaxSize: 25 << 20,
+			AllowedTypes: []string{
+				"image/png", "image/jpeg", "image/gif", "image/webp",
+				"video/mp4", "audio/mpeg", "application/pdf", "text/plain",
+			},
+		},
+		WebSocket: WebSocketConfig{
+			PingInterval:   50 * time.Second,
+			PongWait:       60 * time.Second,
+			WriteWait:      10 * time.Second,
+			MaxMessageSize: 64 << 10,
+			SendQueueSize:  256,
+			OverflowPolicy: "drop_oldest",
+			CoalesceTypes:  []string{"presence", "typing", "readReceipt"},
+			FanOut:         "local",
+			FanOutChannel:  "social_app_events",
+		},
+		EventLog: EventLogConfig{
+			Retention:     7 * 24 * time.Hour,
+			MaxReplay:     1000,
+			PruneInterval: time.Hour,
+		},
+		Reactions: ReactionsConfig{
+			MaxDistinct: 20,
+		},
+		Janitor: JanitorConfig{
+			SessionsInterval:       time.Hour,
+			SessionRetention:       7 * 24 * time.Hour,
+			FriendRequestsInterval: time.Hour,
+			FriendRequestTTL:       30 * 24 * time.Hour,
+			AuthAttemptsInterval:   time.Hour,
+			FilesInterval:          6 * time.Hour,
+			UnsentAttachmentTTL:    24 * time.Hour,
+			FileGracePeriod:        time.Hour,
+		},
+		RateLimit: RateLimitConfig{
+			Enabled:         true,
+			Window:          time.Hour,
+			FreeAttempts:    3,
+			BaseDelay:       time.Second,
+			MaxDelay:        time.Minute,
+			EmailLockout:    10,
+			IPLockout:       50,
+			LockoutDuration: 15 * time.Minute,
+		},
+		Mail: MailConfig{
+			Driver:    "file",
+			From:      "no-reply@localhost",
+			SMTPPort:  587,
+			OutboxDir: "./outbox",
+		},
+		PasswordReset: PasswordResetConfig{
+			URL:      "http://localhost:3000/reset-password",
+			TokenTTL: time.Hour,
+		},
+	}
+}
+
+func (c *Config) Validate() error {
+	var errs []error
+
+	if _, _, err := net.SplitHostPort(c.Server.ListenAddr); err != nil {
+		errs = append(errs, fmt.Errorf("server.listen_addr: %w", err))
+	}
+	if c.Server.AdminAddr != "" {
+		host, _, err := net.SplitHostPort(c.Server.AdminAddr)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("server.admin_addr: %w", err))
+		} else if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			errs = append(errs, fmt.Errorf("server.admin_addr: %q is not a loopback address", host))
+		}
+	}
+	if _, err := c.Server.ProxyPrefixes(); err != nil {
+		errs = append(errs, fmt.Errorf("server.trusted_proxies: %w", err))
+	}
+
+	switch c.Database.Driver {
+	case "postgres":
+		if c.Database.Host == "" || c.Database.Name == "" || c.Database.User == "" {
+			errs = append(errs, errors.New("database: host, name and user are required"))
+		}
+		if c.Database.Port < 1 || c.Database.Port > 65535 {
+			errs = append(errs, fmt.Errorf("database.port: %d is out of range", c.Database.Port))
+		}
+	case "memory":
+	default:
+		errs = append(errs, fmt.Errorf("database.driver: unknown driver %q", c.Database.Driver))
+	}
+
+	if c.Session.Duration <= 0 {
+		errs = append(errs, errors.New("session.duration must be positive"))
+	}
+	if c.Session.MaxLifetime < 0 || c.Session.TouchInterval < 0 {
+		errs = append(errs, errors.New("session: max_lifetime and touch_interval must not be negative"))
+	}
+
+	if c.Uploads.Dir == "" {
+		errs = append(errs, errors.New("uploads.dir is required"))
+	}
+	if c.Uploads.MaxProfilePictureSize <= 0 {
+		errs = append(errs, errors.New("uploads.max_profile_picture_size must be positive"))
+	}
+
+	if c.Attachments.Dir == "" || c.Attachments.MaxSize <= 0 || len(c.Attachments.AllowedTypes) == 0 {
+		errs = append(errs, errors.New("attachments: dir, max_size and allowed_types are required"))
+	} else if isSubdir(c.Uploads.Dir, c.Attachments.Dir) {
+		errs = append(errs, errors.New("attachments.dir must not be inside the publicly served uploads.dir"))
+	}
+
+	if c.WebSocket.PingInterval <= 0 || c.WebSocket.PongWait <= 0 || c.WebSocket.WriteWait <= 0 {
+		errs = append(errs, errors.New("websocket: ping_interval, pong_wait and write_wait must be positive"))
+	}
+	if c.WebSocket.PingInterval >= c.WebSocket.PongWait {
+		errs = append(errs, errors.New("websocket.ping_interval must be shorter than websocket.pong_wait"))
+	}
+	if c.WebSocket.MaxMessageSize <= 0 {
+		errs = append(errs, errors.New("websocket.max_message_size must be positive"))
+	}
+	if c.WebSocket.SendQueueSize <= 0 {
+		errs = append(errs, errors.New("websocket.send_queue_size must be positive"))
+	}
+	switch c.WebSocket.FanOut {
+	case "local":
+	case "postgres":
+		if c.Database.Driver != "postgres" {
+			errs = append(errs, errors.New("websocket.fan_out: postgres fan-out requires the postgres database driver"))
+		}
+		if c.WebSocket.FanOutChannel == "" {
+			errs = append(errs, errors.New("websocket.fan_out_channel is required"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("websocket.fan_out: unknown fan-out %q", c.WebSocket.FanOut))
+	}
+	switch c.WebSocket.OverflowPolicy {
+	case "drop_oldest", "disconnect", "coalesce":
+	default:
+		errs = append(errs, fmt.Errorf("websocket.overflow_policy: unknown policy %q", c.WebSocket.OverflowPolicy))
+	}
+
+	if c.EventLog.Retention <= 0 || c.EventLog.PruneInterval <= 0 {
+		errs = append(errs, errors.New("event_log: retention and prune_interval must be positive"))
+	}
+	if c.EventLog.MaxReplay <= 0 {
+		errs = append(errs, errors.New("event_log.max_replay must be positive"))
+	}
+
+	if c.Reactions.MaxDistinct < 0 {
+		errs = append(errs, errors.New("reactions.max_distinct can not be negative"))
+	}
+
+	if c.Janitor.SessionsInterval < 0 || c.Janitor.FriendRequestsInterval < 0 || c.Janitor.AuthAttemptsInterval < 0 || c.Janitor.FilesInterval < 0 {
+		errs = append(errs, errors.New("janitor: intervals can not be negative"))
+	}
+	if c.Janitor.SessionRetention < 0 || c.Janitor.FileGracePeriod < 0 {
+		errs = append(errs, errors.New("janitor: session_retention and file_grace_period can not be negative"))
+	}
+	if c.Janitor.FriendRequestTTL <= 0 || c.Janitor.UnsentAttachmentTTL <= 0 {
+		errs = append(errs, errors.New("janitor: friend_request_ttl and unsent_attachment_ttl must be positive"))
+	}
+
+	if c.RateLimit.Enabled {
+		rl := c.RateLimit
+		if rl.Window <= 0 || rl.BaseDelay <= 0 || rl.MaxDelay < rl.BaseDelay || rl.LockoutDuration <= 0 {
+			errs = append(errs, errors.New("rate_limit: window, base_delay and lockout_duration must be positive and max_delay at least base_delay"))
+		}
+		if rl.LockoutDuration > rl.Window {
+			errs = append(errs, errors.New("rate_limit.lockout_duration must not be longer than rate_limit.window"))
+		}
+		if rl.FreeAttempts < 0 || rl.EmailLockout <= rl.FreeAttempts || rl.IPLockout <= rl.FreeAttempts {
+			errs = append(errs, errors.New("rate_limit: email_lockout and ip_lockout must be greater than free_attempts"))
+		}
+	}
+
+	if _, err := mail.ParseAddress(c.Mail.From); err != nil {
+		errs = append(errs, fmt.Errorf("mail.from: %w", err))
+	}
+	switch c.Mail.Driver {
+	case "smtp":
+		if c.Mail.SMTPHost == "" || c.Mail.SMTPPort < 1 || c.Mail.SMTPPort > 65535 {
+			errs = append(errs, errors.New("mail: smtp driver requires smtp_host and a valid smtp_port"))
+		}
+	case "file":
+		if c.Mail.OutboxDir == "" {
+			errs = append(errs, errors.New("mail.outbox_dir is required by the file driver"))
+		}
+	case "memory":
+	default:
+		errs = append(errs, fmt.Errorf("mail.driver: unknown driver %q", c.Mail.Driver))
+	}
+
+	if u, err := url.Parse(c.PasswordReset.URL); err != nil || u.Scheme == "" || u.Host == "" {
+		errs = append(errs, errors.New("password_reset.url must be an absolute URL"))
+	}
+	if c.PasswordReset.TokenTTL <= 0 {
+		errs = append(errs, errors.New("password_reset.token_ttl must be positive"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// ProxyPrefixes parses TrustedProxies, a plain IP becomes a single address prefix.
+func (c ServerConfig) ProxyPrefixes() ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(c.TrustedProxies))
+	for _, proxy := range c.TrustedProxies {
+		if addr, err := netip.ParseAddr(proxy); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an IP or CIDR", proxy)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return prefixes, nil
+}
+
+// ConnString returns the lib/pq connection string for the database.
+func (c DatabaseConfig) ConnString() string {
+	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		quoteConnValue(c.Host), c.Port, quoteConnValue(c.User), quoteConnValue(c.Password),
+		quoteConnValue(c.Name), quoteConnValue(c.SSLMode))
+}
+
+var connValueEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+
+func quoteConnValue(v string) string {
+	return "'" + connValueEscaper.Replace(v) + "'"
+}
+
+// ProfilePictureDir is where uploaded profile pictures are stored.
+func (c UploadsConfig) ProfilePictureDir() string {
+	return c.Dir + "/pfp"
+}
+
+// isSubdir reports whether dir is parent itself or a directory inside it.
+func isSubdir(parent, dir string) bool {
+	rel, err := filepath.Rel(parent, dir)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,219 @@
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const envPrefix = "SOCIAL_"
+
+// setting is a single leaf of Config, addressed by "section.key".
+type setting struct {
+	name  string
+	usage string
+	value reflect.Value
+}
+
+// Env returns the environment variable name of the setting,
+// e.g. server.listen_addr -> SOCIAL_SERVER_LISTEN_ADDR.
+func (s setting) Env() string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(s.name, ".", "_"))
+}
+
+func (c *Config) settings() []setting {
+	var settings []setting
+
+	root := reflect.ValueOf(c).Elem()
+	for i := 0; i < root.NumField(); i++ {
+		section := root.Field(i)
+		sectionName := jsonName(root.Type().Field(i))
+
+		for j := 0; j < section.NumField(); j++ {
+			field := section.Type().Field(j)
+			settings = append(settings, setting{
+				name:  sectionName + "." + jsonName(field),
+				usage: field.Tag.Get("usage"),
+				value: section.Field(j),
+			})
+		}
+	}
+
+	return settings
+}
+
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	return name
+}
+
+// Load builds the configuration from defaults, the config file, the environment
+// and args, in that order, and validates it. The config file is taken from the
+// -config flag or SOCIAL_CONFIG. Load returns the positional arguments left
+// after flag parsing.
+func Load(args []string) (*Config, []string, error) {
+	cfg := Default()
+	settings := cfg.settings()
+
+	fs := flag.NewFlagSet("social-app", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv(envPrefix+"CONFIG"), "path to a JSON config file")
+	for _, s := range settings {
+		fs.String(s.name, "", fmt.Sprintf("%s (env %s)", s.usage, s.Env()))
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	if *configPath != "" {
+		if err := cfg.loadFile(*configPath, settings); err != nil {
+			return nil, nil, fmt.Errorf("failed to load config file: %w", err)
+		}
+	}
+
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(s.Env()); ok {
+			if err := setValue(s.value, v); err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", s.Env(), err)
+			}
+		}
+	}
+
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.name == f.Name && flagErr == nil {
+				if err := setValue(s.value, f.Value.String()); err != nil {
+					flagErr = fmt.Errorf("-%s: %w", f.Name, err)
+				}
+			}
+		}
+	})
+	if flagErr != nil {
+		return nil, nil, flagErr
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return cfg, fs.Args(), nil
+}
+
+func (c *Config) loadFile(path string, settings []setting) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var sections map[string]map[string]any
+	if err := json.Unmarshal(content, &sections); err != nil {
+		return err
+	}
+
+	for sectionName, values := range sections {
+		for key, raw := range values {
+			name := sectionName + "." + key
+
+			var target *setting
+			for i := range settings {
+				if settings[i].name == name {
+					target = &settings[i]
+					break
+				}
+			}
+			if target == nil {
+				return fmt.Errorf("unknown setting %q", name)
+			}
+
+			v, err := jsonValueString(raw)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+
+			if err := setValue(target.value, v); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// jsonValueString converts a decoded JSON value into the same textual form
+// accepted from environment variables and flags.
+func jsonValueString(raw any) (string, error) {
+	switch v := raw.(type) {
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := jsonValueString(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	default:
+		return "", fmt.Errorf("unsupported value %v", raw)
+	}
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func setValue(target reflect.Value, v string) error {
+	if target.Type() == durationType {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		target.SetInt(int64(d))
+		return nil
+	}
+
+	switch target.Kind() {
+	case reflect.String:
+		target.SetString(v)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return err
+		}
+		target.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return err
+		}
+		target.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		target.SetBool(b)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		target.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported setting type %s", target.Type())
+	}
+
+	return nil
+}
@@ -0,0 +1,190 @@
+// Package janitor runs the periodic cleanup of the server: expired sessions,
+// stale friend requests, the event log, old failed auth attempts and files
+// nothing references anymore.
+//
+// Every instance schedules the tasks, but storage.RunScheduled makes sure a
+// task runs on one instance at a time and at most once per interval.
+package janitor
+
+import (
+	"errors"
+	"expvar"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/carson2222/social-app/config"
+	"github.com/carson2222/social-app/storage"
+)
+
+// metrics is published on /debug/vars under "janitor". For every task it
+// counts runs, runs skipped because another instance had it, errors and
+// deleted items, and records the unix time of the last run.
+var metrics = expvar.NewMap("janitor")
+
+// task is a cleanup job. run returns how many items it deleted.
+type task struct {
+	name     string
+	interval time.Duration
+	run      func() (int64, error)
+}
+
+type Janitor struct {
+	config  *config.Config
+	storage storage.Storage
+	tasks   []task
+}
+
+func New(cfg *config.Config, storage storage.Storage) *Janitor {
+	j := &Janitor{
+		config:  cfg,
+		storage: storage,
+	}
+
+	j.tasks = []task{
+		{"sessions", cfg.Janitor.SessionsInterval, j.purgeSessions},
+		{"friend_requests", cfg.Janitor.FriendRequestsInterval, j.expireFriendRequests},
+		{"event_log", cfg.EventLog.PruneInterval, j.pruneEventLog},
+		{"auth_attempts", cfg.Janitor.AuthAttemptsInterval, j.purgeAuthFailures},
+		{"files", cfg.Janitor.FilesInterval, j.collectFiles},
+	}
+
+	return j
+}
+
+// Start schedules every enabled task, running each once right away.
+func (j *Janitor) Start() {
+	for _, t := range j.tasks {
+		if t.interval <= 0 {
+			continue
+		}
+
+		for _, name := range []string{"runs", "skipped", "errors", "deleted", "last_run"} {
+			metrics.Add(t.name+"_"+name, 0)
+		}
+
+		go j.schedule(t)
+	}
+}
+
+func (j *Janitor) schedule(t task) {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	for {
+		j.runTask(t)
+		<-ticker.C
+	}
+}
+
+func (j *Janitor) runTask(t task) {
+	var deleted int64
+
+	// Tickers of different instances drift, leave some slack
+	ran, err := j.storage.RunScheduled(t.name, t.interval*9/10, func() error {
+		var err error
+		deleted, err = t.run()
+		return err
+	})
+
+	if !ran {
+		if err != nil {
+			metrics.Add(t.name+"_errors", 1)
+			log.Printf("Error scheduling janitor task %s: %v", t.name, err)
+		} else {
+			metrics.Add(t.name+"_skipped", 1)
+		}
+		return
+	}
+
+	metrics.Add(t.name+"_runs", 1)
+	metrics.Add(t.name+"_deleted", deleted)
+	if v, ok := metrics.Get(t.name + "_last_run").(*expvar.Int); ok {
+		v.Set(time.Now().Unix())
+	}
+
+	if err != nil {
+		metrics.Add(t.name+"_errors", 1)
+		log.Printf("Error running janitor task %s: %v", t.name, err)
+		return
+	}
+
+	if deleted > 0 {
+		log.Printf("Janitor task %s deleted %d items", t.name, deleted)
+	}
+}
+
+func (j *Janitor) purgeSessions() (int64, error) {
+	return j.storage.PurgeSessions(time.Now().Add(-j.config.Janitor.SessionRetention))
+}
+
+func (j *Janitor) expireFriendRequests() (int64, error) {
+	return j.storage.ExpireFriendRequests(time.Now().Add(-j.config.Janitor.FriendRequestTTL))
+}
+
+func (j *Janitor) pruneEventLog() (int64, error) {
+	return j.storage.PruneEvents(time.Now().Add(-j.config.EventLog.Retention))
+}
+
+func (j *Janitor) purgeAuthFailures() (int64, error) {
+	return j.storage.PurgeAuthFailures(time.Now().Add(-j.config.RateLimit.Window))
+}
+
+// collectFiles deletes attachments that were never sent, then every profile
+// picture and attachment file that no row references. Files younger than the
+// grace period are kept, their row may not be written yet.
+func (j *Janitor) collectFiles() (int64, error) {
+	now := time.Now()
+
+	unsent, err := j.storage.DeleteUnsentAttachments(now.Add(-j.config.Janitor.UnsentAttachmentTTL))
+	if err != nil {
+		return 0, err
+	}
+	if unsent > 0 {
+		log.Printf("Deleted %d unsent attachments", unsent)
+	}
+
+	paths, err := j.storage.GetReferencedFiles()
+	if err != nil {
+		return 0, err
+	}
+
+	referenced := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		referenced[filepath.Clean(path)] = true
+	}
+
+	cutoff := now.Add(-j.config.Janitor.FileGracePeriod)
+	deleted := int64(0)
+	for _, dir := range []string{j.config.Uploads.ProfilePictureDir(), j.config.Attachments.Dir} {
+		entries, err := os.ReadDir(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return deleted, err
+		}
+
+		for _, entry := range entries {
+			path := filepath.Join(dir, entry.Name())
+			if !entry.Type().IsRegular() || referenced[path] {
+				continue
+			}
+
+			info, err := entry.Info()
+			if err != nil || info.ModTime().After(cutoff) {
+				continue
+			}
+
+			if err := os.Remove(path); err != nil {
+				log.Printf("Error deleting unreferenced file %s: %v", path, err)
+				continue
+			}
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"os"
 
 	"github.com/carson2222/social-app/api"
+	"github.com/carson2222/social-app/config"
+	"github.com/carson2222/social-app/janitor"
+	"github.com/carson2222/social-app/mailer"
 	"github.com/carson2222/social-app/storage"
 	"github.com/carson2222/social-app/ws"
 )
@@ -12,18 +18,79 @@ func main() {
 	// DEV
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
-	storage, err := storage.NewPostgresStorage()
+	cfg, args, err := config.Load(os.Args[1:])
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	if err := storage.Init(); err != nil {
+	store, err := openStorage(cfg, args)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if store == nil {
+		return
+	}
+
+	fanOut, err := openFanOut(cfg, store)
+	if err != nil {
 		log.Fatal(err)
 	}
 
-	wsServer := ws.NewWebSocketServer(storage)
+	wsServer := ws.NewWebSocketServer(cfg, store, fanOut)
 
-	server := api.NewAPIServer("127.0.0.1:3000", storage, wsServer)
+	janitor.New(cfg, store).Start()
+
+	mail, err := mailer.New(cfg.Mail)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	server := api.NewAPIServer(cfg, store, wsServer, mail)
 
 	server.Run()
 }
+
+// openStorage creates the configured storage backend. It returns a nil
+// Storage when args named a command that has already been run.
+func openStorage(cfg *config.Config, args []string) (storage.Storage, error) {
+	if cfg.Database.Driver == "memory" {
+		if len(args) > 0 {
+			return nil, errors.New("commands require the postgres driver")
+		}
+		return storage.NewMemoryStorage(cfg), nil
+	}
+
+	pgStore, err := storage.NewPostgresStorage(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args) > 0 && args[0] == "migrate" {
+		return nil, runMigrate(pgStore, args[1:])
+	}
+	if len(args) > 0 {
+		return nil, fmt.Errorf("unknown command: %s", args[0])
+	}
+
+	if cfg.Database.AutoMigrate {
+		if err := pgStore.Init(); err != nil {
+			return nil, err
+		}
+	}
+
+	return pgStore, nil
+}
+
+// openFanOut creates the configured fan-out between server instances.
+func openFanOut(cfg *config.Config, store storage.Storage) (ws.FanOut, error) {
+	if cfg.WebSocket.FanOut != "postgres" {
+		return ws.NewLocalFanOut(), nil
+	}
+
+	pgStore, ok := store.(*storage.PostgresStore)
+	if !ok {
+		return nil, errors.New("postgres fan-out requires the postgres storage")
+	}
+
+	return pgStore.NewFanOut(cfg.WebSocket.FanOutChannel)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/carson2222/social-app/storage"
+)
+
+const migrateUsage = "usage: migrate up | down [steps] | status"
+
+// runMigrate handles the "migrate" command.
+func runMigrate(store *storage.PostgresStore, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	switch args[0] {
+	case "up":
+		count, err := store.MigrateUp()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Applied %d migration(s)\n", count)
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			var err error
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps: %s", args[1])
+			}
+		}
+
+		count, err := store.MigrateDown(steps)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Reverted %d migration(s)\n", count)
+
+	case "status":
+		status, err := store.MigrationStatus()
+		if err != nil {
+			return err
+		}
+
+		for _, m := range status {
+			if m.Applied {
+				fmt.Printf("%04d_%s\tapplied at %s\n", m.Version, m.Name, m.AppliedAt.Format("2006-01-02 15:04:05"))
+			} else {
+				fmt.Printf("%04d_%s\tpending\n", m.Version, m.Name)
+			}
+		}
+
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	return nil
+}
@@ -0,0 +1,173 @@
+// Package ratelimit slows down brute-force attempts on the auth endpoints.
+//
+// Failed attempts are recorded per client IP and per email (per user for
+// signed in users) in a Store, so they survive restarts and are shared by
+// every instance. A key may fail a few times freely; after that every further attempt has to wait a delay that doubles
+// with each failure, and once the key reaches its lockout threshold it is
+// locked until the lockout duration has passed since its last failure.
+package ratelimit
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/carson2222/social-app/config"
+)
+
+// Store records failed attempts. storage.Storage implements it, both the
+// Postgres and the in-memory backend.
+type Store interface {
+	RecordAuthFailure(key string, at time.Time) error
+	GetAuthFailures(key string, since time.Time) (int, time.Time, error)
+	ResetAuthFailures(key string) error
+}
+
+type Limiter struct {
+	config config.RateLimitConfig
+	store  Store
+	now    func() time.Time
+}
+
+func New(cfg config.RateLimitConfig, store Store) *Limiter {
+	return &Limiter{
+		config: cfg,
+		store:  store,
+		now:    time.Now,
+	}
+}
+
+// key is a rate limited subject and the failures that lock it.
+type key struct {
+	name    string
+	lockout int
+}
+
+func (l *Limiter) keys(ip, email string) []key {
+	keys := []key{{"ip:" + ip, l.config.IPLockout}}
+	if email != "" {
+		keys = append(keys, key{"email:" + strings.ToLower(email), l.config.EmailLockout})
+	}
+	return keys
+}
+
+// Check returns how long the client must wait before it may try to
+// authenticate as email, 0 if it may try now. email may be empty.
+func (l *Limiter) Check(ip, email string) (time.Duration, error) {
+	return l.wait(l.keys(ip, email))
+}
+
+// wait returns how long until every key may be tried again.
+func (l *Limiter) wait(keys []key) (time.Duration, error) {
+	if !l.config.Enabled {
+		return 0, nil
+	}
+
+	now := l.now()
+	wait := time.Duration(0)
+	for _, k := range keys {
+		failures, last, err := l.store.GetAuthFailures(k.name, now.Add(-l.config.Window))
+		if err != nil {
+			return 0, err
+		}
+
+		wait = max(wait, last.Add(l.delay(failures, k.lockout)).Sub(now))
+	}
+
+	return wait, nil
+}
+
+// delay is the time a key must wait after its last failure.
+func (l *Limiter) delay(failures, lockout int) time.Duration {
+	if failures >= lockout {
+		return l.config.LockoutDuration
+	}
+	if failures < l.config.FreeAttempts {
+		return 0
+	}
+
+	delay := l.config.BaseDelay
+	for i := l.config.FreeAttempts; i < failures && delay < l.config.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, l.config.MaxDelay)
+}
+
+// userKeys are the keys of a signed in user checking their password from ip.
+// The user key uses the email lockout, so rotating IPs doesn't help a stolen
+// session guess the password.
+func (l *Limiter) userKeys(ip string, userId int) []key {
+	return []key{
+		{"ip:" + ip, l.config.IPLockout},
+		{"user:" + strconv.Itoa(userId), l.config.EmailLockout},
+	}
+}
+
+// CheckUser returns how long the signed in user must wait before their
+// password may be checked again, 0 if it may be checked now.
+func (l *Limiter) CheckUser(ip string, userId int) (time.Duration, error) {
+	return l.wait(l.userKeys(ip, userId))
+}
+
+// FailUser records a wrong password given by a signed in user from ip.
+func (l *Limiter) FailUser(ip string, userId int) error {
+	return l.record(l.userKeys(ip, userId))
+}
+
+// SucceedUser clears the failures of a signed in user, but not those of the IP.
+func (l *Limiter) SucceedUser(userId int) error {
+	if !l.config.Enabled {
+		return nil
+	}
+
+	return l.store.ResetAuthFailures("user:" + strconv.Itoa(userId))
+}
+
+// forgotKey counts password reset requests for email. It is separate from the
+// login keys, so flooding someone's inbox can't lock them out of logging in.
+func (l *Limiter) forgotKey(email string) key {
+	return key{"forgot:" + strings.ToLower(email), l.config.EmailLockout}
+}
+
+// CheckForgot returns how long a password reset for email must wait, 0 if it
+// may be requested now.
+func (l *Limiter) CheckForgot(email string) (time.Duration, error) {
+	return l.wait([]key{l.forgotKey(email)})
+}
+
+// FailForgot counts a password reset request for email. Every request counts,
+// whether the email belongs to a user or not.
+func (l *Limiter) FailForgot(email string) error {
+	return l.record([]key{l.forgotKey(email)})
+}
+
+// Fail records a failed attempt from ip as email. email may be empty.
+func (l *Limiter) Fail(ip, email string) error {
+	return l.record(l.keys(ip, email))
+}
+
+func (l *Limiter) record(keys []key) error {
+	if !l.config.Enabled {
+		return nil
+	}
+
+	now := l.now()
+	for _, k := range keys {
+		if err := l.store.RecordAuthFailure(k.name, now); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Succeed clears the failures of email after a successful attempt. The
+// failures of the IP stay, or one valid account would let an attacker reset
+// the IP's count.
+func (l *Limiter) Succeed(email string) error {
+	if !l.config.Enabled || email == "" {
+		return nil
+	}
+
+	return l.store.ResetAuthFailures("email:" + strings.ToLower(email))
+}
@@ -0,0 +1,267 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/carson2222/social-app/config"
+	"github.com/carson2222/social-app/storage"
+)
+
+var testConfig = config.RateLimitConfig{
+	Enabled:         true,
+	Window:          time.Hour,
+	FreeAttempts:    2,
+	BaseDelay:       time.Second,
+	MaxDelay:        4 * time.Second,
+	EmailLockout:    6,
+	IPLockout:       8,
+	LockoutDuration: 10 * time.Minute,
+}
+
+// newTestLimiter returns a limiter on the in-memory backend whose clock only
+// moves when the returned advance is called.
+func newTestLimiter(cfg config.RateLimitConfig) (*Limiter, func(time.Duration)) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	l := New(cfg, storage.NewMemoryStorage(config.Default()))
+	l.now = func() time.Time { return now }
+
+	return l, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		elapsed  time.Duration
+		want     time.Duration
+	}{
+		{"no failures", 0, 0, 0},
+		{"free attempt", 1, 0, 0},
+		{"first delay", 2, 0, time.Second},
+		{"delay doubles", 3, 0, 2 * time.Second},
+		{"delay doubles again", 4, 0, 4 * time.Second},
+		{"delay capped", 5, 0, 4 * time.Second},
+		{"delay partly waited", 4, 3 * time.Second, time.Second},
+		{"delay waited", 4, 5 * time.Second, 0},
+		{"lockout", 6, 0, 10 * time.Minute},
+		{"lockout partly waited", 6, 9 * time.Minute, time.Minute},
+		{"lockout waited", 6, 10 * time.Minute, 0},
+		{"failures out of window", 6, 2 * time.Hour, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, advance := newTestLimiter(testConfig)
+
+			for range tt.failures {
+				if err := l.Fail("1.2.3.4", "user@example.com"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			advance(tt.elapsed)
+
+			wait, err := l.Check("1.2.3.4", "user@example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if wait != tt.want {
+				t.Errorf("Check() = %v, want %v", wait, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckKeys(t *testing.T) {
+	tests := []struct {
+		name  string
+		fail  func(l *Limiter) error
+		ip    string
+		email string
+		want  time.Duration
+	}{
+		{
+			name: "email is case insensitive",
+			fail: func(l *Limiter) error {
+				for range 6 {
+					if err := l.Fail("1.2.3.4", "User@Example.com"); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			ip: "5.6.7.8", email: "user@example.com",
+			want: 10 * time.Minute,
+		},
+		{
+			name: "ip locked across emails",
+			fail: func(l *Limiter) error {
+				for i := range 8 {
+					if err := l.Fail("1.2.3.4", string(rune('a'+i))+"@example.com"); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			ip: "1.2.3.4", email: "new@example.com",
+			want: 10 * time.Minute,
+		},
+		{
+			name: "other ip and email unaffected",
+			fail: func(l *Limiter) error {
+				for range 8 {
+					if err := l.Fail("1.2.3.4", "user@example.com"); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			ip: "5.6.7.8", email: "other@example.com",
+			want: 0,
+		},
+		{
+			name: "succeed resets the email only",
+			fail: func(l *Limiter) error {
+				for range 6 {
+					if err := l.Fail("1.2.3.4", "user@example.com"); err != nil {
+						return err
+					}
+				}
+				return l.Succeed("USER@example.com")
+			},
+			ip: "1.2.3.4", email: "user@example.com",
+			want: 4 * time.Second,
+		},
+		{
+			name: "succeed clears the email for other ips",
+			fail: func(l *Limiter) error {
+				for range 6 {
+					if err := l.Fail("1.2.3.4", "user@example.com"); err != nil {
+						return err
+					}
+				}
+				return l.Succeed("user@example.com")
+			},
+			ip: "5.6.7.8", email: "user@example.com",
+			want: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, _ := newTestLimiter(testConfig)
+
+			if err := tt.fail(l); err != nil {
+				t.Fatal(err)
+			}
+
+			wait, err := l.Check(tt.ip, tt.email)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if wait != tt.want {
+				t.Errorf("Check() = %v, want %v", wait, tt.want)
+			}
+		})
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	cfg := testConfig
+	cfg.Enabled = false
+	l, _ := newTestLimiter(cfg)
+
+	for range 10 {
+		if err := l.Fail("1.2.3.4", "user@example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wait, err := l.Check("1.2.3.4", "user@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait != 0 {
+		t.Errorf("Check() = %v, want 0", wait)
+	}
+}
+
+func TestForgotBucket(t *testing.T) {
+	l, _ := newTestLimiter(testConfig)
+
+	for range 6 {
+		if err := l.FailForgot("User@example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wait, err := l.CheckForgot("user@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait != 10*time.Minute {
+		t.Errorf("CheckForgot() = %v, want %v", wait, 10*time.Minute)
+	}
+
+	// Reset requests must not lock the login of the email
+	wait, err = l.Check("1.2.3.4", "user@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait != 0 {
+		t.Errorf("Check() = %v, want 0", wait)
+	}
+
+	// Nor do failed logins limit reset requests
+	for range 6 {
+		if err := l.Fail("1.2.3.4", "other@example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wait, err = l.CheckForgot("other@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait != 0 {
+		t.Errorf("CheckForgot() = %v, want 0", wait)
+	}
+}
+
+func TestUserKey(t *testing.T) {
+	l, _ := newTestLimiter(testConfig)
+
+	// Rotating IPs does not get around the user's lockout
+	for i := range 6 {
+		if err := l.FailUser("10.0.0."+string(rune('1'+i)), 42); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wait, err := l.CheckUser("5.6.7.8", 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait != 10*time.Minute {
+		t.Errorf("CheckUser() = %v, want %v", wait, 10*time.Minute)
+	}
+
+	wait, err = l.CheckUser("5.6.7.8", 43)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait != 0 {
+		t.Errorf("CheckUser() of another user = %v, want 0", wait)
+	}
+
+	if err := l.SucceedUser(42); err != nil {
+		t.Fatal(err)
+	}
+	wait, err = l.CheckUser("5.6.7.8", 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait != 0 {
+		t.Errorf("CheckUser() after SucceedUser = %v, want 0", wait)
+	}
+}
@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/carson2222/social-app/types"
+	"github.com/lib/pq"
+)
+
+// attachmentColumns selects a types.Attachment, see scanAttachment.
+const attachmentColumns = `attachments.id, attachments.uploader_id, COALESCE(attachments.message_id, 0), COALESCE(messages.chat_id, 0),
+attachments.path, attachments.original_name, attachments.mime_type, attachments.size, attachments.width, attachments.height,
+attachments.created_at
+FROM attachments LEFT JOIN messages ON messages.id = attachments.message_id`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAttachment(row rowScanner) (types.Attachment, error) {
+	var a types.Attachment
+	err := row.Scan(&a.ID, &a.UploaderID, &a.MessageID, &a.ChatID, &a.Path, &a.OriginalName, &a.MimeType,
+		&a.Size, &a.Width, &a.Height, &a.CreatedAt)
+
+	return a, err
+}
+
+// CreateAttachment stores the metadata of an uploaded file that is not sent yet.
+func (s *PostgresStore) CreateAttachment(attachment types.Attachment) (int, error) {
+	query := `INSERT INTO attachments (uploader_id, path, original_name, mime_type, size, width, height, created_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id;`
+
+	var attachmentId int
+	err := s.db.QueryRow(query, attachment.UploaderID, attachment.Path, attachment.OriginalName, attachment.MimeType,
+		attachment.Size, attachment.Width, attachment.Height, attachment.CreatedAt).Scan(&attachmentId)
+
+	return attachmentId, err
+}
+
+func (s *PostgresStore) GetAttachment(attachmentId int) (types.Attachment, error) {
+	query := `SELECT ` + attachmentColumns + ` WHERE attachments.id = $1;`
+
+	attachment, err := scanAttachment(s.db.QueryRow(query, attachmentId))
+	if errors.Is(err, sql.ErrNoRows) {
+		return attachment, ErrAttachmentNotFound
+	}
+
+	return attachment, err
+}
+
+// getAttachments returns the attachments of the given messages by message id.
+func (s *PostgresStore) getAttachments(messageIds []int) (map[int][]types.Attachment, error) {
+	query := `SELECT ` + attachmentColumns + ` WHERE attachments.message_id = ANY($1) ORDER BY attachments.id;`
+
+	rows, err := s.db.Query(query, pq.Array(messageIds))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attachments := make(map[int][]types.Attachment)
+	for rows.Next() {
+		attachment, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		attachments[attachment.MessageID] = append(attachments[attachment.MessageID], attachment)
+	}
+
+	return attachments, rows.Err()
+}
@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+)
+
+func (s *PostgresStore) RecordAuthFailure(key string, at time.Time) error {
+	query := `INSERT INTO auth_attempts (key, created_at) VALUES ($1, $2);`
+
+	_, err := s.db.Exec(query, key, at)
+	return err
+}
+
+// GetAuthFailures returns how many failed attempts were made with key since
+// the given time, and when the last one was.
+func (s *PostgresStore) GetAuthFailures(key string, since time.Time) (int, time.Time, error) {
+	query := `SELECT COUNT(*), MAX(created_at) FROM auth_attempts WHERE key = $1 AND created_at >= $2;`
+
+	var count int
+	var last sql.NullTime
+	if err := s.db.QueryRow(query, key, since).Scan(&count, &last); err != nil {
+		return 0, time.Time{}, err
+	}
+
+	return count, last.Time, nil
+}
+
+func (s *PostgresStore) ResetAuthFailures(key string) error {
+	query := `DELETE FROM auth_attempts WHERE key = $1;`
+
+	_, err := s.db.Exec(query, key)
+	return err
+}
+
+// PurgeAuthFailures deletes failed attempts made before the given time.
+func (s *PostgresStore) PurgeAuthFailures(before time.Time) (int64, error) {
+	query := `DELETE FROM auth_attempts WHERE created_at < $1;`
+
+	result, err := s.db.Exec(query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,78 @@
+package storage
+
+import "fmt"
+
+// BlockUser blocks blockedId for blockerId and ends any friendship or pending
+// friend request between them.
+func (s *PostgresStore) BlockUser(blockerId, blockedId int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO blocks (blocker_id, blocked_id) VALUES ($1, $2) ON CONFLICT DO NOTHING;`
+	if _, err := tx.Exec(query, blockerId, blockedId); err != nil {
+		return fmt.Errorf("failed to add block: %w", err)
+	}
+
+	query = `DELETE FROM friends WHERE user_id = $1 AND friend_id = $2 OR user_id = $2 AND friend_id = $1;`
+	if _, err := tx.Exec(query, blockerId, blockedId); err != nil {
+		return fmt.Errorf("failed to remove friend: %w", err)
+	}
+
+	query = `DELETE FROM friend_requests WHERE sender_id = $1 AND receiver_id = $2 OR sender_id = $2 AND receiver_id = $1;`
+	if _, err := tx.Exec(query, blockerId, blockedId); err != nil {
+		return fmt.Errorf("failed to delete friend requests: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// UnblockUser removes a block and reports whether there was one.
+func (s *PostgresStore) UnblockUser(blockerId, blockedId int) (bool, error) {
+	query := `DELETE FROM blocks WHERE blocker_id = $1 AND blocked_id = $2;`
+
+	result, err := s.db.Exec(query, blockerId, blockedId)
+	if err != nil {
+		return false, err
+	}
+
+	removed, err := result.RowsAffected()
+	return removed > 0, err
+}
+
+// IsBlocked reports whether either user blocked the other.
+func (s *PostgresStore) IsBlocked(user1, user2 int) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM blocks WHERE blocker_id = $1 AND blocked_id = $2 OR blocker_id = $2 AND blocked_id = $1);`
+
+	var blocked bool
+	err := s.db.QueryRow(query, user1, user2).Scan(&blocked)
+
+	return blocked, err
+}
+
+// HasBlocked reports whether blockerId blocked blockedId.
+func (s *PostgresStore) HasBlocked(blockerId, blockedId int) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM blocks WHERE blocker_id = $1 AND blocked_id = $2);`
+
+	var blocked bool
+	err := s.db.QueryRow(query, blockerId, blockedId).Scan(&blocked)
+
+	return blocked, err
+}
+
+func (s *PostgresStore) GetBlockedUsers(userId int) ([]int, error) {
+	query := `SELECT blocked_id FROM blocks WHERE blocker_id = $1 ORDER BY created_at;`
+
+	return s.queryIds(query, userId)
+}
+
+// GetChatBlockers returns the members of the chat who blocked userId.
+func (s *PostgresStore) GetChatBlockers(chatId, userId int) ([]int, error) {
+	query := `SELECT blocks.blocker_id FROM blocks
+JOIN chat_users ON chat_users.user_id = blocks.blocker_id AND chat_users.chat_id = $1
+WHERE blocks.blocked_id = $2;`
+
+	return s.queryIds(query, chatId, userId)
+}
@@ -1,49 +1,14 @@
 package storage
 
 import (
+	"database/sql"
+	"errors"
+	"fmt"
 	"time"
-)
-
-func (s *PostgresStore) createChatsTable() error {
-
-	query := `CREATE TABLE IF NOT EXISTS chats (
-    id SERIAL PRIMARY KEY,
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    is_group BOOLEAN DEFAULT FALSE,
-		name TEXT
-);`
-
-	_, err := s.db.Exec(query)
-
-	return err
-}
 
-func (s *PostgresStore) createMessagesTable() error {
-	query := `CREATE TABLE IF NOT EXISTS messages (
-    id SERIAL PRIMARY KEY,
-    chat_id INTEGER REFERENCES chats(id) ON DELETE CASCADE,
-    sender_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
-    content TEXT NOT NULL,
-    sent_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-);
-`
-
-	_, err := s.db.Exec(query)
-
-	return err
-}
-func (s *PostgresStore) createChatUsersTable() error {
-	query := `CREATE TABLE IF NOT EXISTS chat_users (
-    chat_id INTEGER REFERENCES chats(id) ON DELETE CASCADE,
-    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
-    PRIMARY KEY (chat_id, user_id)
-);
-`
-
-	_, err := s.db.Exec(query)
-
-	return err
-}
+	"github.com/carson2222/social-app/types"
+	"github.com/lib/pq"
+)
 
 func (s *PostgresStore) GetUserChats(userId int) (map[int]bool, error) {
 	query := `SELECT chat_id FROM chat_users WHERE user_id = $1;`
@@ -66,13 +31,83 @@ func (s *PostgresStore) GetUserChats(userId int) (map[int]bool, error) {
 	return chatIDs, nil
 }
 
-func (s *PostgresStore) NewMessage(chatID int, senderID int, content string, sentAt time.Time) (int, error) {
-	query := `INSERT INTO messages (chat_id, sender_id, content, sent_at) VALUES ($1, $2, $3, $4) RETURNING id;`
+func (s *PostgresStore) GetChatMembers(chatId int) ([]int, error) {
+	query := `SELECT user_id FROM chat_users WHERE chat_id = $1 ORDER BY user_id;`
+
+	rows, err := s.db.Query(query, chatId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []int
+	for rows.Next() {
+		var userId int
+		if err := rows.Scan(&userId); err != nil {
+			return nil, err
+		}
+		members = append(members, userId)
+	}
+
+	return members, rows.Err()
+}
+
+// NewMessage stores a message, replyToId is 0 when it does not reply to another
+// message. The attachments must have been uploaded by the sender and not sent yet.
+func (s *PostgresStore) NewMessage(chatID int, senderID int, content string, replyToId int, attachmentIds []int, sentAt time.Time) (int, error) {
+	return s.insertMessage(chatID, senderID, content, replyToId, attachmentIds, false, sentAt)
+}
+
+// NewSystemMessage stores a system message describing a change actorId made to the chat.
+func (s *PostgresStore) NewSystemMessage(chatId, actorId int, content string, attachmentIds []int, sentAt time.Time) (int, error) {
+	return s.insertMessage(chatId, actorId, content, 0, attachmentIds, true, sentAt)
+}
+
+func (s *PostgresStore) insertMessage(chatID, senderID int, content string, replyToId int, attachmentIds []int, isSystem bool, sentAt time.Time) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return -1, err
+	}
+	defer tx.Rollback()
+
+	messageID, err := insertMessageTx(tx, chatID, senderID, content, replyToId, attachmentIds, isSystem, sentAt)
+	if err != nil {
+		return -1, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return -1, err
+	}
+
+	return messageID, nil
+}
+
+// insertMessageTx stores a message and attaches attachmentIds to it within tx.
+func insertMessageTx(tx *sql.Tx, chatID, senderID int, content string, replyToId int, attachmentIds []int, isSystem bool, sentAt time.Time) (int, error) {
+	query1 := `INSERT INTO messages (chat_id, sender_id, content, reply_to_id, is_system, sent_at)
+VALUES ($1, $2, $3, NULLIF($4, 0), $5, $6) RETURNING id;`
 
 	messageID := -1
-	err := s.db.QueryRow(query, chatID, senderID, content, sentAt).Scan(&messageID)
+	if err := tx.QueryRow(query1, chatID, senderID, content, replyToId, isSystem, sentAt).Scan(&messageID); err != nil {
+		return -1, err
+	}
 
-	return messageID, err
+	if len(attachmentIds) > 0 {
+		query2 := `UPDATE attachments SET message_id = $1 WHERE id = ANY($2) AND uploader_id = $3 AND message_id IS NULL;`
+
+		result, err := tx.Exec(query2, messageID, pq.Array(attachmentIds), senderID)
+		if err != nil {
+			return -1, err
+		}
+
+		if n, err := result.RowsAffected(); err != nil {
+			return -1, err
+		} else if n != int64(len(attachmentIds)) {
+			return -1, ErrAttachmentNotFound
+		}
+	}
+
+	return messageID, nil
 }
 
 func (s *PostgresStore) IsUserInChat(userId, chatId int) error {
@@ -80,15 +115,30 @@ func (s *PostgresStore) IsUserInChat(userId, chatId int) error {
 	query := `SELECT EXISTS (SELECT 1 FROM chat_users WHERE user_id = $1 AND chat_id = $2);`
 
 	var exists bool
-	err := s.db.QueryRow(query, userId, chatId).Scan(&exists)
-
-	if err != nil || !exists {
+	if err := s.db.QueryRow(query, userId, chatId).Scan(&exists); err != nil {
 		return err
 	}
 
+	if !exists {
+		return ErrNotInChat
+	}
+
 	return nil
 }
 
+// GetChatRole returns the user's role in the chat, or ErrNotInChat.
+func (s *PostgresStore) GetChatRole(userId, chatId int) (string, error) {
+	query := `SELECT role FROM chat_users WHERE user_id = $1 AND chat_id = $2;`
+
+	var role string
+	err := s.db.QueryRow(query, userId, chatId).Scan(&role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotInChat
+	}
+
+	return role, err
+}
+
 func (s *PostgresStore) InitNewChat(chatName string, members []int) (int, error) {
 	tx, err := s.db.Begin()
 	if err != nil {
@@ -120,11 +170,16 @@ func (s *PostgresStore) InitNewChat(chatName string, members []int) (int, error)
 		return -1, err
 	}
 
-	// Add members to chat
-	query2 := `INSERT INTO chat_users (chat_id, user_id) VALUES ($1, $2);`
+	// Add members to chat, the first member of a group created it and owns it
+	query2 := `INSERT INTO chat_users (chat_id, user_id, role) VALUES ($1, $2, $3);`
 
-	for _, member := range members {
-		_, err = tx.Exec(query2, chatId, member)
+	for i, member := range members {
+		role := types.ChatRoleMember
+		if isGroup && i == 0 {
+			role = types.ChatRoleOwner
+		}
+
+		_, err = tx.Exec(query2, chatId, member, role)
 		if err != nil {
 			return -1, err
 		}
@@ -138,29 +193,96 @@ func (s *PostgresStore) InitNewChat(chatName string, members []int) (int, error)
 	return chatId, nil
 }
 
-func (s *PostgresStore) IsPrivateChatExisting(user1 int, user2 int) (bool, error) {
-	var exists bool
+// directKey identifies the 1:1 chat of two users regardless of their order.
+func directKey(user1, user2 int) string {
+	return fmt.Sprintf("%d:%d", min(user1, user2), max(user1, user2))
+}
 
-	query := `SELECT COUNT(id) > 0 AS chat_exists
-FROM chats
-JOIN chat_users cu1 ON chats.id = cu1.chat_id
-JOIN chat_users cu2 ON chats.id = cu2.chat_id
-WHERE chats.is_group = false
-AND cu1.user_id = $1
-AND cu2.user_id = $2
-`
-
-	err := s.db.QueryRow(query, user1, user2).Scan(&exists)
+// GetOrCreateDirectChat returns the 1:1 chat of two users, creating it if it
+// does not exist yet, and reports whether it was created. The unique direct_key
+// makes concurrent calls for the same pair agree on a single chat.
+func (s *PostgresStore) GetOrCreateDirectChat(user1, user2 int) (types.Chat, bool, error) {
+	tx, err := s.db.Begin()
 	if err != nil {
-		return false, err
+		return types.Chat{}, false, err
 	}
-	return exists, nil
+	defer tx.Rollback()
+
+	key := directKey(user1, user2)
+	chat := types.Chat{}
+
+	query1 := `INSERT INTO chats (is_group, direct_key) VALUES (false, $1)
+ON CONFLICT (direct_key) DO NOTHING RETURNING id, created_at;`
+
+	err = tx.QueryRow(query1, key).Scan(&chat.ID, &chat.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Someone else created it, possibly a moment ago
+		query2 := `SELECT id, created_at FROM chats WHERE direct_key = $1;`
+		if err := tx.QueryRow(query2, key).Scan(&chat.ID, &chat.CreatedAt); err != nil {
+			return types.Chat{}, false, err
+		}
+
+		return chat, false, tx.Commit()
+	}
+	if err != nil {
+		return types.Chat{}, false, err
+	}
+
+	query3 := `INSERT INTO chat_users (chat_id, user_id, role) VALUES ($1, $2, $3), ($1, $4, $3);`
+	if _, err := tx.Exec(query3, chat.ID, user1, types.ChatRoleMember, user2); err != nil {
+		return types.Chat{}, false, err
+	}
+
+	return chat, true, tx.Commit()
 }
 
-// func (s *PostgresStore) GetChatsInfo(userId int) error {
+// GetChatsInfo returns the user's chats ordered by last activity, newest
+// first. Deleted messages and those the user hid are skipped, a chat without
+// any other message uses its creation time as last activity. Pass
+// beforeChatId = 0 for the first page, otherwise the last activity and id of
+// the last chat of the previous page. Non-system messages sent by someone else
+// after the user's read cursor count as unread.
+func (s *PostgresStore) GetChatsInfo(userId int, beforeActivity time.Time, beforeChatId, limit int) ([]types.ChatShortInfo, error) {
+
+	query := `SELECT * FROM (
+SELECT chats.id AS chat_id, chats.created_at, chats.is_group, COALESCE(chats.name, ''), COALESCE(chats.avatar_attachment_id, 0),
+(SELECT COUNT(*) FROM chat_users members WHERE members.chat_id = chats.id),
+(SELECT COUNT(*) FROM messages unread WHERE unread.chat_id = chats.id
+AND unread.id > chat_users.last_read_message_id AND unread.sender_id IS DISTINCT FROM $1
+AND NOT unread.is_system AND unread.deleted_at IS NULL
+AND NOT EXISTS (SELECT 1 FROM message_hidden WHERE message_hidden.message_id = unread.id AND message_hidden.user_id = $1)),
+COALESCE(last_message.id, 0), COALESCE(last_message.sender_id, 0), COALESCE(last_message.content, ''), COALESCE(last_message.is_system, false),
+COALESCE(last_message.sent_at, chats.created_at) AS last_activity,
+COALESCE(profiles.name, ''), COALESCE(profiles.surname, ''), COALESCE(profiles.pfp, '')
+FROM chats
+JOIN chat_users ON chat_users.chat_id = chats.id AND chat_users.user_id = $1
+LEFT JOIN LATERAL (SELECT id, sender_id, content, is_system, sent_at FROM messages
+WHERE messages.chat_id = chats.id AND messages.deleted_at IS NULL
+AND NOT EXISTS (SELECT 1 FROM message_hidden WHERE message_hidden.message_id = messages.id AND message_hidden.user_id = $1)
+ORDER BY id DESC LIMIT 1) last_message ON true
+LEFT JOIN profiles ON profiles.user_id = last_message.sender_id
+) chat_list
+WHERE $2 = 0 OR (last_activity, chat_id) < ($3, $2)
+ORDER BY last_activity DESC, chat_id DESC
+LIMIT $4;`
+
+	rows, err := s.db.Query(query, userId, beforeChatId, beforeActivity, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-// 	query := `SELECT chats.id as chat_id, created_at, is_group, chats.name, messages.sender_id, messages.content, messages.sent_at,
-// profiles.name, profiles.surname, profiles.pfp
-// FROM chats JOIN chat_users ON chat_users.chat_id = chats.id LEFT JOIN LATERAL (SELECT * FROM messages WHERE chat_id = chats.id
-// ORDER BY sent_at desc LIMIT 1) messages on true LEFT JOIN profiles on messages.sender_id = profiles.user_id WHERE chat_users.user_id = 26`
-// }
+	chats := []types.ChatShortInfo{}
+	for rows.Next() {
+		var c types.ChatShortInfo
+		err := rows.Scan(&c.ChatId, &c.CreatedAt, &c.IsGroup, &c.Name, &c.AvatarID, &c.MemberCount, &c.UnreadCount,
+			&c.LastMessageId, &c.LastSenderId, &c.Message, &c.MessageIsSystem, &c.LastActivity,
+			&c.LastSenderName, &c.LastSenderSurname, &c.LastSenderPfp)
+		if err != nil {
+			return nil, err
+		}
+		chats = append(chats, c)
+	}
+
+	return chats, rows.Err()
+}
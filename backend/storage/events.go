@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/carson2222/social-app/types"
+	"github.com/lib/pq"
+)
+
+// ErrCursorExpired is returned by GetEventsSince when events after the cursor
+// have already been pruned from the log.
+var ErrCursorExpired = errors.New("event cursor expired")
+
+// AppendEvent stores an event for userIds and returns its id. Appends are
+// serialized so ids commit in order: a client that has seen an id must never
+// see a lower one appear later, or replay and the live path would both skip it.
+func (s *PostgresStore) AppendEvent(eventType string, data []byte, userIds []int) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT 1 FROM event_log_state FOR UPDATE;`); err != nil {
+		return 0, err
+	}
+
+	query := `INSERT INTO events (type, data) VALUES ($1, $2) RETURNING id;`
+
+	var eventId int64
+	if err := tx.QueryRow(query, eventType, string(data)).Scan(&eventId); err != nil {
+		return 0, err
+	}
+
+	query = `INSERT INTO event_recipients (user_id, event_id)
+SELECT DISTINCT unnest($1::int[]), $2 ON CONFLICT DO NOTHING;`
+	if _, err := tx.Exec(query, pq.Array(userIds), eventId); err != nil {
+		return 0, err
+	}
+
+	return eventId, tx.Commit()
+}
+
+func (s *PostgresStore) GetEventsSince(userId int, since int64, limit int) ([]types.Event, error) {
+	var prunedThrough int64
+	if err := s.db.QueryRow(`SELECT pruned_through FROM event_log_state;`).Scan(&prunedThrough); err != nil {
+		return nil, err
+	}
+
+	if since < prunedThrough {
+		return nil, ErrCursorExpired
+	}
+
+	query := `SELECT events.id, events.type, events.data, events.created_at
+FROM event_recipients JOIN events ON events.id = event_recipients.event_id
+WHERE event_recipients.user_id = $1 AND event_recipients.event_id > $2
+ORDER BY event_recipients.event_id
+LIMIT $3;`
+
+	rows, err := s.db.Query(query, userId, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []types.Event
+	for rows.Next() {
+		var event types.Event
+		var data string
+		if err := rows.Scan(&event.ID, &event.Type, &data, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		event.Data = []byte(data)
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+func (s *PostgresStore) GetLatestEventID(userId int) (int64, error) {
+	query := `SELECT COALESCE(MAX(event_id), 0) FROM event_recipients WHERE user_id = $1;`
+
+	var eventId int64
+	err := s.db.QueryRow(query, userId).Scan(&eventId)
+
+	return eventId, err
+}
+
+// PruneEvents deletes events created before the given time and returns how
+// many were deleted.
+func (s *PostgresStore) PruneEvents(before time.Time) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var lastId sql.NullInt64
+	if err := tx.QueryRow(`SELECT MAX(id) FROM events WHERE created_at < $1;`, before).Scan(&lastId); err != nil {
+		return 0, err
+	}
+
+	if !lastId.Valid {
+		return 0, nil
+	}
+
+	result, err := tx.Exec(`DELETE FROM events WHERE id <= $1;`, lastId.Int64)
+	if err != nil {
+		return 0, err
+	}
+
+	query := `UPDATE event_log_state SET pruned_through = GREATEST(pruned_through, $1);`
+	if _, err := tx.Exec(query, lastId.Int64); err != nil {
+		return 0, err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return deleted, tx.Commit()
+}
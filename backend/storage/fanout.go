@@ -0,0 +1,237 @@
+package storage
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/carson2222/social-app/types"
+	"github.com/lib/pq"
+)
+
+const (
+	// maxNotifyPayload keeps NOTIFY payloads below Postgres' 8000 byte limit.
+	// Larger events go through the fanout_payloads table.
+	maxNotifyPayload = 7000
+	// fanOutSeenSize is how many recent event ids are remembered for deduplication.
+	fanOutSeenSize = 4096
+	// fanOutPayloadTTL is how long stored payloads are kept for slow listeners.
+	fanOutPayloadTTL = 10 * time.Minute
+)
+
+type fanOutEnvelope struct {
+	ID      string          `json:"id"`
+	Node    string          `json:"node"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	Ref     int64           `json:"ref,omitempty"`
+}
+
+// fanOutMetrics is published on /debug/vars under "fan_out".
+var fanOutMetrics = expvar.NewMap("fan_out")
+
+// PostgresFanOut distributes broadcast events between server instances with
+// LISTEN/NOTIFY. Events published on this instance are delivered locally
+// right away; events from other instances arrive through the listener, which
+// reconnects on its own. NOTIFY is not durable: events published while the
+// listener is reconnecting are lost, so a types.EventFanOutGap is delivered
+// after it reconnects and local clients must catch up from the event log.
+type PostgresFanOut struct {
+	db       *sql.DB
+	listener *pq.Listener
+	channel  string
+	nodeID   string
+	seq      atomic.Uint64
+	messages chan []byte
+
+	seenMu    sync.Mutex
+	seen      map[string]bool
+	seenOrder []string
+}
+
+func (s *PostgresStore) NewFanOut(channel string) (*PostgresFanOut, error) {
+	nodeBytes := make([]byte, 8)
+	if _, err := rand.Read(nodeBytes); err != nil {
+		return nil, err
+	}
+
+	listener := pq.NewListener(s.config.Database.ConnString(), time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		switch event {
+		case pq.ListenerEventDisconnected:
+			log.Printf("Fan-out listener disconnected: %v", err)
+		case pq.ListenerEventReconnected:
+			log.Println("Fan-out listener reconnected")
+		case pq.ListenerEventConnectionAttemptFailed:
+			log.Printf("Fan-out listener failed to reconnect: %v", err)
+		}
+	})
+
+	if err := listener.Listen(channel); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to listen on %s: %w", channel, err)
+	}
+
+	f := &PostgresFanOut{
+		db:       s.db,
+		listener: listener,
+		channel:  channel,
+		nodeID:   hex.EncodeToString(nodeBytes),
+		messages: make(chan []byte),
+		seen:     make(map[string]bool),
+	}
+
+	go f.listen()
+
+	return f, nil
+}
+
+func (f *PostgresFanOut) Messages() <-chan []byte {
+	return f.messages
+}
+
+// Publish delivers msg locally and notifies the other instances. The event is
+// already stored and delivered here when notifying fails, so the failure is
+// only logged; clients of other instances catch up from the event log.
+func (f *PostgresFanOut) Publish(msg []byte) error {
+	envelope := fanOutEnvelope{
+		ID:      fmt.Sprintf("%s-%d", f.nodeID, f.seq.Add(1)),
+		Node:    f.nodeID,
+		Payload: msg,
+	}
+	f.markSeen(envelope.ID)
+
+	// Deliver to this instance without waiting for the database
+	f.messages <- msg
+
+	if err := f.notify(envelope); err != nil {
+		fanOutMetrics.Add("notify_errors", 1)
+		log.Printf("Error notifying other instances: %v", err)
+	}
+
+	return nil
+}
+
+func (f *PostgresFanOut) notify(envelope fanOutEnvelope) error {
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+
+	if len(payload) > maxNotifyPayload {
+		query := `INSERT INTO fanout_payloads (payload) VALUES ($1) RETURNING id;`
+		if err := f.db.QueryRow(query, string(envelope.Payload)).Scan(&envelope.Ref); err != nil {
+			return fmt.Errorf("failed to store fan-out payload: %w", err)
+		}
+
+		envelope.Payload = nil
+		if payload, err = json.Marshal(envelope); err != nil {
+			return err
+		}
+	}
+
+	if _, err := f.db.Exec(`SELECT pg_notify($1, $2);`, f.channel, string(payload)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (f *PostgresFanOut) Close() error {
+	return f.listener.Close()
+}
+
+func (f *PostgresFanOut) listen() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case notification, ok := <-f.listener.Notify:
+			if !ok {
+				return
+			}
+
+			// A nil notification means the connection was re-established,
+			// events published while it was down were missed
+			if notification == nil {
+				log.Println("Fan-out listener resumed, clients must catch up")
+				fanOutMetrics.Add("gaps", 1)
+				f.deliverGap()
+				continue
+			}
+
+			if err := f.receive(notification.Extra); err != nil {
+				log.Printf("Error receiving fan-out event: %v", err)
+			}
+
+		case <-ticker.C:
+			go f.listener.Ping()
+
+			query := `DELETE FROM fanout_payloads WHERE created_at < $1;`
+			if _, err := f.db.Exec(query, time.Now().Add(-fanOutPayloadTTL)); err != nil {
+				log.Printf("Error deleting old fan-out payloads: %v", err)
+			}
+		}
+	}
+}
+
+func (f *PostgresFanOut) receive(payload string) error {
+	var envelope fanOutEnvelope
+	if err := json.Unmarshal([]byte(payload), &envelope); err != nil {
+		return err
+	}
+
+	// Own events were delivered locally when published
+	if envelope.Node == f.nodeID || !f.markSeen(envelope.ID) {
+		return nil
+	}
+
+	msg := []byte(envelope.Payload)
+	if envelope.Ref != 0 {
+		var stored string
+		query := `SELECT payload FROM fanout_payloads WHERE id = $1;`
+		if err := f.db.QueryRow(query, envelope.Ref).Scan(&stored); err != nil {
+			return fmt.Errorf("failed to load fan-out payload %d: %w", envelope.Ref, err)
+		}
+		msg = []byte(stored)
+	}
+
+	f.messages <- msg
+	return nil
+}
+
+// deliverGap tells this instance that events of other instances were missed.
+func (f *PostgresFanOut) deliverGap() {
+	msg, err := json.Marshal(types.OutgoingBase{Type: types.EventFanOutGap})
+	if err != nil {
+		log.Printf("Error marshaling fan-out gap: %v", err)
+		return
+	}
+
+	f.messages <- msg
+}
+
+// markSeen records id and reports whether it had not been seen before.
+func (f *PostgresFanOut) markSeen(id string) bool {
+	f.seenMu.Lock()
+	defer f.seenMu.Unlock()
+
+	if f.seen[id] {
+		return false
+	}
+
+	f.seen[id] = true
+	f.seenOrder = append(f.seenOrder, id)
+	if len(f.seenOrder) > fanOutSeenSize {
+		delete(f.seen, f.seenOrder[0])
+		f.seenOrder = f.seenOrder[1:]
+	}
+
+	return true
+}
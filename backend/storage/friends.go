@@ -2,31 +2,6 @@ package storage
 
 import "fmt"
 
-func (s *PostgresStore) createFriendsTable() error {
-	query := `CREATE TABLE IF NOT EXISTS friends (
-    user_id INTEGER REFERENCES users (id) ON DELETE CASCADE,
-    friend_id INTEGER REFERENCES users (id) ON DELETE CASCADE,
-		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-    PRIMARY KEY (user_id, friend_id)
-);`
-
-	_, err := s.db.Exec(query)
-
-	return err
-}
-func (s *PostgresStore) createFriendRequestsTable() error {
-	query := `CREATE TABLE IF NOT EXISTS friend_requests (
-		sender_id INTEGER REFERENCES users (id) ON DELETE CASCADE NOT NULL,
-		receiver_id INTEGER REFERENCES users (id) ON DELETE CASCADE NOT NULL,
-		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-		PRIMARY KEY (sender_id, receiver_id)
-	);`
-
-	_, err := s.db.Exec(query)
-
-	return err
-}
-
 func (s *PostgresStore) AreFriends(userId, friendId int) (bool, error) {
 	query := `SELECT EXISTS (SELECT 1 FROM friends WHERE user_id = $1 AND friend_id = $2 OR user_id = $2 AND friend_id = $1);`
 
@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/carson2222/social-app/types"
+)
+
+func (s *PostgresStore) GetChat(chatId int) (types.Chat, error) {
+	query := `SELECT id, created_at, is_group, COALESCE(name, ''), COALESCE(avatar_attachment_id, 0) FROM chats WHERE id = $1;`
+
+	var chat types.Chat
+	err := s.db.QueryRow(query, chatId).Scan(&chat.ID, &chat.CreatedAt, &chat.IsGroup, &chat.Name, &chat.AvatarID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return chat, ErrChatNotFound
+	}
+
+	return chat, err
+}
+
+func (s *PostgresStore) AddChatMembers(chatId int, userIds []int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO chat_users (chat_id, user_id, role) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING;`
+	for _, userId := range userIds {
+		if _, err := tx.Exec(query, chatId, userId, types.ChatRoleMember); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// RemoveChatMember removes the user from the chat. When the owner leaves, the
+// first admin (or, without admins, the first member) by user id becomes the
+// owner; its id is returned, 0 if ownership did not change.
+func (s *PostgresStore) RemoveChatMember(chatId, userId int) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query1 := `DELETE FROM chat_users WHERE chat_id = $1 AND user_id = $2 RETURNING role;`
+
+	var role string
+	if err := tx.QueryRow(query1, chatId, userId).Scan(&role); errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotInChat
+	} else if err != nil {
+		return 0, err
+	}
+
+	newOwner := 0
+	if role == types.ChatRoleOwner {
+		query2 := `UPDATE chat_users SET role = $2 WHERE chat_id = $1 AND user_id = (
+SELECT user_id FROM chat_users WHERE chat_id = $1 ORDER BY role = $3 DESC, user_id LIMIT 1
+) RETURNING user_id;`
+
+		err := tx.QueryRow(query2, chatId, types.ChatRoleOwner, types.ChatRoleAdmin).Scan(&newOwner)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return 0, err
+		}
+	}
+
+	return newOwner, tx.Commit()
+}
+
+// SetChatRole changes the role of a member. Making someone the owner demotes
+// the current owner to admin.
+func (s *PostgresStore) SetChatRole(chatId, userId int, role string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if role == types.ChatRoleOwner {
+		query := `UPDATE chat_users SET role = $2 WHERE chat_id = $1 AND role = $3;`
+		if _, err := tx.Exec(query, chatId, types.ChatRoleAdmin, types.ChatRoleOwner); err != nil {
+			return err
+		}
+	}
+
+	query := `UPDATE chat_users SET role = $3 WHERE chat_id = $1 AND user_id = $2;`
+	result, err := tx.Exec(query, chatId, userId, role)
+	if err != nil {
+		return err
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotInChat
+	}
+
+	return tx.Commit()
+}
+
+func (s *PostgresStore) RenameChat(chatId int, name string) error {
+	query := `UPDATE chats SET name = $2 WHERE id = $1;`
+
+	_, err := s.db.Exec(query, chatId, name)
+	return err
+}
+
+// SetChatAvatar sets the group picture, attachmentId 0 removes it. The system
+// message announcing the change is stored in the same transaction, with the
+// picture attached to it.
+func (s *PostgresStore) SetChatAvatar(chatId, actorId int, content string, attachmentId int, sentAt time.Time) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return -1, err
+	}
+	defer tx.Rollback()
+
+	var attachmentIds []int
+	if attachmentId != 0 {
+		attachmentIds = []int{attachmentId}
+	}
+
+	messageId, err := insertMessageTx(tx, chatId, actorId, content, 0, attachmentIds, true, sentAt)
+	if err != nil {
+		return -1, err
+	}
+
+	query := `UPDATE chats SET avatar_attachment_id = NULLIF($2, 0) WHERE id = $1;`
+	if _, err := tx.Exec(query, chatId, attachmentId); err != nil {
+		return -1, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return -1, err
+	}
+
+	return messageId, nil
+}
@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// RunScheduled runs fn for the named task unless another instance is running
+// it or it last ran less than interval ago, and reports whether it ran. The
+// task is locked with a session level advisory lock, held on a dedicated
+// connection while fn runs.
+func (s *PostgresStore) RunScheduled(task string, interval time.Duration, fn func() error) (bool, error) {
+	ctx := context.Background()
+
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock(hashtext($1));`, task).Scan(&locked); err != nil {
+		return false, err
+	}
+	if !locked {
+		return false, nil
+	}
+	defer conn.ExecContext(ctx, `SELECT pg_advisory_unlock(hashtext($1));`, task)
+
+	// Another instance may have run the task just before us
+	var lastRun time.Time
+	err = conn.QueryRowContext(ctx, `SELECT last_run FROM janitor_runs WHERE task = $1;`, task).Scan(&lastRun)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return false, err
+	}
+
+	now := time.Now()
+	if err == nil && now.Sub(lastRun) < interval {
+		return false, nil
+	}
+
+	if err := fn(); err != nil {
+		return true, err
+	}
+
+	query := `INSERT INTO janitor_runs (task, last_run) VALUES ($1, $2)
+ON CONFLICT (task) DO UPDATE SET last_run = EXCLUDED.last_run;`
+	_, err = conn.ExecContext(ctx, query, task, now)
+
+	return true, err
+}
+
+// PurgeSessions deletes sessions that expired, or were signed out and last
+// used, before the given time.
+func (s *PostgresStore) PurgeSessions(before time.Time) (int64, error) {
+	query := `DELETE FROM sessions WHERE expires_at < $1 OR (NOT is_valid AND last_active < $1);`
+
+	result, err := s.db.Exec(query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// ExpireFriendRequests deletes friend requests sent before the given time.
+func (s *PostgresStore) ExpireFriendRequests(before time.Time) (int64, error) {
+	query := `DELETE FROM friend_requests WHERE created_at < $1;`
+
+	result, err := s.db.Exec(query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// DeleteUnsentAttachments deletes attachments uploaded before the given time
+// that were never sent. Their files are left to the file collector.
+func (s *PostgresStore) DeleteUnsentAttachments(before time.Time) (int64, error) {
+	query := `DELETE FROM attachments WHERE message_id IS NULL AND created_at < $1;`
+
+	result, err := s.db.Exec(query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// GetReferencedFiles returns the paths of every profile picture and attachment
+// file still in use.
+func (s *PostgresStore) GetReferencedFiles() ([]string, error) {
+	query := `SELECT pfp FROM profiles WHERE pfp IS NOT NULL AND pfp <> ''
+UNION SELECT path FROM attachments;`
+
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	paths := []string{}
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+
+	return paths, rows.Err()
+}
@@ -0,0 +1,1477 @@
+package storage
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/carson2222/social-app/config"
+	"github.com/carson2222/social-app/types"
+)
+
+type memUser struct {
+	id        int
+	email     string
+	salt      []byte
+	password  []byte
+	createdAt time.Time
+}
+
+type memSession struct {
+	id         int
+	userId     int
+	userAgent  string
+	ip         string
+	createdAt  time.Time
+	expiresAt  time.Time
+	lastActive time.Time
+	isValid    bool
+}
+
+type memReset struct {
+	userId    int
+	expiresAt time.Time
+	used      bool
+}
+
+type memChat struct {
+	id        int
+	createdAt time.Time
+	isGroup   bool
+	name      string
+	members   map[int]bool
+	roles     map[int]string
+	lastRead  map[int]int
+	avatarId  int
+	directKey string
+}
+
+type memMessage struct {
+	id        int
+	chatId    int
+	senderId  int
+	replyToId int
+	content   string
+	isSystem  bool
+	sentAt    time.Time
+	editedAt  *time.Time
+	deletedAt *time.Time
+	edits     []types.MessageEdit
+	hiddenFor map[int]bool
+	reactions []memReaction
+}
+
+type memReaction struct {
+	userId   int
+	reaction string
+}
+
+type memEvent struct {
+	event      types.Event
+	recipients map[int]bool
+}
+
+// userPair is an ordered (first, second) pair of user ids.
+type userPair [2]int
+
+// MemoryStore is a thread-safe in-memory Storage used by tests and local demos.
+type MemoryStore struct {
+	mu     sync.RWMutex
+	config *config.Config
+
+	users    map[int]*memUser
+	emails   map[string]int
+	sessions map[string]*memSession // Keyed by token hash
+	profiles map[int]*types.Profile
+
+	friends        map[userPair]time.Time
+	friendRequests map[userPair]time.Time
+	blocks         map[userPair]time.Time
+
+	chats       map[int]*memChat
+	messages    []*memMessage
+	attachments map[int]*types.Attachment
+
+	events        []*memEvent
+	prunedThrough int64
+
+	authFailures map[string][]time.Time
+	resets       map[string]*memReset // Keyed by token hash
+
+	taskRuns    map[string]time.Time
+	tasksMu     sync.Mutex
+	tasksActive map[string]bool
+
+	lastUserId       int
+	lastSessionId    int
+	lastChatId       int
+	lastMessageId    int
+	lastAttachmentId int
+	lastEventId      int64
+}
+
+func NewMemoryStorage(cfg *config.Config) *MemoryStore {
+	return &MemoryStore{
+		config:         cfg,
+		users:          make(map[int]*memUser),
+		emails:         make(map[string]int),
+		sessions:       make(map[string]*memSession),
+		profiles:       make(map[int]*types.Profile),
+		friends:        make(map[userPair]time.Time),
+		friendRequests: make(map[userPair]time.Time),
+		blocks:         make(map[userPair]time.Time),
+		chats:          make(map[int]*memChat),
+		attachments:    make(map[int]*types.Attachment),
+		authFailures:   make(map[string][]time.Time),
+		resets:         make(map[string]*memReset),
+		taskRuns:       make(map[string]time.Time),
+		tasksActive:    make(map[string]bool),
+	}
+}
+
+func hashMemoryPassword(salt []byte, password string) []byte {
+	hash := sha256.New()
+	hash.Write(salt)
+	hash.Write([]byte(password))
+	return hash.Sum(nil)
+}
+
+func (s *MemoryStore) CreateUser(c *types.Credentials) (int, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return -1, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.emails[c.Email]; ok {
+		return -1, errors.New("email already registered")
+	}
+
+	s.lastUserId++
+	user := &memUser{
+		id:        s.lastUserId,
+		email:     c.Email,
+		salt:      salt,
+		password:  hashMemoryPassword(salt, c.Password),
+		createdAt: time.Now(),
+	}
+	s.users[user.id] = user
+	s.emails[user.email] = user.id
+
+	return user.id, nil
+}
+
+func (s *MemoryStore) AuthUser(c *types.Credentials) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, ok := s.emails[c.Email]
+	if !ok {
+		return -1, sql.ErrNoRows
+	}
+
+	user := s.users[id]
+	if subtle.ConstantTimeCompare(user.password, hashMemoryPassword(user.salt, c.Password)) != 1 {
+		return -1, sql.ErrNoRows
+	}
+
+	return user.id, nil
+}
+
+func (s *MemoryStore) GetUserIDByEmail(email string) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, ok := s.emails[email]
+	if !ok {
+		return -1, ErrUserNotFound
+	}
+
+	return id, nil
+}
+
+func (s *MemoryStore) CheckPassword(userId int, password string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[userId]
+	if !ok {
+		return false, ErrUserNotFound
+	}
+
+	return subtle.ConstantTimeCompare(user.password, hashMemoryPassword(user.salt, password)) == 1, nil
+}
+
+func (s *MemoryStore) SetPassword(userId int, password string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.setPassword(userId, password)
+}
+
+// setPassword must be called with mu held.
+func (s *MemoryStore) setPassword(userId int, password string) error {
+	user, ok := s.users[userId]
+	if !ok {
+		return ErrUserNotFound
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+
+	user.salt = salt
+	user.password = hashMemoryPassword(salt, password)
+	return nil
+}
+
+func (s *MemoryStore) CreatePasswordReset(userId int, tokenHash string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for hash, reset := range s.resets {
+		if reset.userId == userId && !reset.used {
+			delete(s.resets, hash)
+		}
+	}
+
+	s.resets[tokenHash] = &memReset{userId: userId, expiresAt: expiresAt}
+	return nil
+}
+
+func (s *MemoryStore) ResetPassword(tokenHash, password string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reset, ok := s.resets[tokenHash]
+	if !ok || reset.used || !time.Now().Before(reset.expiresAt) {
+		return -1, ErrResetTokenInvalid
+	}
+
+	if err := s.setPassword(reset.userId, password); err != nil {
+		return -1, err
+	}
+	reset.used = true
+
+	return reset.userId, nil
+}
+
+func (s *MemoryStore) IsUserExisting(id int) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.users[id]
+	return ok, nil
+}
+
+func (s *MemoryStore) CreateSession(userId int, userAgent, ip string) (string, error) {
+	sessionToken, err := NewToken()
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[userId]; !ok {
+		return "", errors.New("user does not exist")
+	}
+
+	now := time.Now()
+	s.lastSessionId++
+	s.sessions[HashToken(sessionToken)] = &memSession{
+		id:         s.lastSessionId,
+		userId:     userId,
+		userAgent:  userAgent,
+		ip:         ip,
+		createdAt:  now,
+		expiresAt:  now.Add(s.config.Session.Duration),
+		lastActive: now,
+		isValid:    true,
+	}
+
+	return sessionToken, nil
+}
+
+func (session *memSession) toSession() types.Session {
+	return types.Session{
+		ID:         session.id,
+		UserID:     session.userId,
+		UserAgent:  session.userAgent,
+		IP:         session.ip,
+		CreatedAt:  session.createdAt,
+		LastActive: session.lastActive,
+		ExpiresAt:  session.expiresAt,
+	}
+}
+
+func (session *memSession) active(now time.Time) bool {
+	return session.isValid && now.Before(session.expiresAt)
+}
+
+func (s *MemoryStore) VerifySession(sessionToken string) (types.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[HashToken(sessionToken)]
+	if !ok {
+		return types.Session{}, sql.ErrNoRows
+	}
+
+	now := time.Now()
+	if now.After(session.expiresAt) {
+		return types.Session{}, errors.New("session expired")
+	}
+
+	if !session.isValid {
+		return types.Session{}, errors.New("session invalid")
+	}
+
+	if touchDue(s.config.Session, session.toSession(), now) {
+		session.expiresAt = slideExpiry(s.config.Session, session.toSession(), now)
+		session.lastActive = now
+	}
+
+	return session.toSession(), nil
+}
+
+func (s *MemoryStore) KillSession(sessionToken string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if session, ok := s.sessions[HashToken(sessionToken)]; ok {
+		session.isValid = false
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) GetSessions(userId int) ([]types.Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	sessions := []types.Session{}
+	for _, session := range s.sessions {
+		if session.userId == userId && session.active(now) {
+			sessions = append(sessions, session.toSession())
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].LastActive.Equal(sessions[j].LastActive) {
+			return sessions[i].LastActive.After(sessions[j].LastActive)
+		}
+		return sessions[i].ID > sessions[j].ID
+	})
+
+	return sessions, nil
+}
+
+func (s *MemoryStore) RevokeSession(userId, sessionId int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, session := range s.sessions {
+		if session.id == sessionId && session.userId == userId && session.active(now) {
+			session.isValid = false
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (s *MemoryStore) RevokeOtherSessions(userId, keepSessionId int) ([]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	revoked := []int{}
+	for _, session := range s.sessions {
+		if session.userId == userId && session.id != keepSessionId && session.active(now) {
+			session.isValid = false
+			revoked = append(revoked, session.id)
+		}
+	}
+	sort.Ints(revoked)
+
+	return revoked, nil
+}
+
+func (s *MemoryStore) InitProfile(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.profiles[id] = &types.Profile{ID: id}
+	return nil
+}
+
+func (s *MemoryStore) UpdateProfile(id int, name, surname, bio, pfp string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	profile, ok := s.profiles[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	if name != "" {
+		profile.Name = name
+	}
+	if surname != "" {
+		profile.Surname = surname
+	}
+	if bio != "" {
+		profile.Bio = bio
+	}
+	if pfp != "" {
+		profile.Pfp = pfp
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) GetProfileByID(id int) (types.Profile, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	profile, ok := s.profiles[id]
+	if !ok {
+		return types.Profile{}, sql.ErrNoRows
+	}
+
+	return *profile, nil
+}
+
+func (s *MemoryStore) AreFriends(userId, friendId int) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok1 := s.friends[userPair{userId, friendId}]
+	_, ok2 := s.friends[userPair{friendId, userId}]
+	return ok1 || ok2, nil
+}
+
+func (s *MemoryStore) IsRequestedFriend(senderId, receiverId int) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.friendRequests[userPair{senderId, receiverId}]
+	return ok, nil
+}
+
+func (s *MemoryStore) GetFriends(userId int) (map[int]bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	friendIDs := make(map[int]bool)
+	for pair := range s.friends {
+		if pair[0] == userId {
+			friendIDs[pair[1]] = true
+		}
+	}
+
+	return friendIDs, nil
+}
+
+func (s *MemoryStore) SendFR(senderId, receiverId int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pair := userPair{senderId, receiverId}
+	if _, ok := s.friendRequests[pair]; ok {
+		return errors.New("friend request already exists")
+	}
+
+	s.friendRequests[pair] = time.Now()
+	return nil
+}
+
+func (s *MemoryStore) AcceptFriendRequest(userId, senderId int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.friendRequests, userPair{senderId, userId})
+
+	now := time.Now()
+	s.friends[userPair{userId, senderId}] = now
+	s.friends[userPair{senderId, userId}] = now
+	return nil
+}
+
+func (s *MemoryStore) RejectFriendRequest(userId, senderId int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.friendRequests, userPair{senderId, userId})
+	return nil
+}
+
+func (s *MemoryStore) RemoveFriend(userId, friendId int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.friends, userPair{userId, friendId})
+	delete(s.friends, userPair{friendId, userId})
+	return nil
+}
+
+func (s *MemoryStore) GetUserChats(userId int) (map[int]bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	chatIDs := make(map[int]bool)
+	for id, chat := range s.chats {
+		if chat.members[userId] {
+			chatIDs[id] = true
+		}
+	}
+
+	return chatIDs, nil
+}
+
+func (s *MemoryStore) IsUserInChat(userId, chatId int) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	chat, ok := s.chats[chatId]
+	if !ok || !chat.members[userId] {
+		return ErrNotInChat
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) InitNewChat(chatName string, members []int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, member := range members {
+		if _, ok := s.users[member]; !ok {
+			return -1, errors.New("user does not exist")
+		}
+	}
+
+	s.lastChatId++
+	chat := &memChat{
+		id:        s.lastChatId,
+		createdAt: time.Now(),
+		isGroup:   len(members) > 2,
+		name:      chatName,
+		members:   make(map[int]bool),
+		roles:     make(map[int]string),
+		lastRead:  make(map[int]int),
+	}
+	for i, member := range members {
+		chat.members[member] = true
+		chat.roles[member] = types.ChatRoleMember
+		if chat.isGroup && i == 0 {
+			chat.roles[member] = types.ChatRoleOwner
+		}
+	}
+	s.chats[chat.id] = chat
+
+	return chat.id, nil
+}
+
+func (s *MemoryStore) NewMessage(chatID, senderID int, content string, replyToId int, attachmentIds []int, sentAt time.Time) (int, error) {
+	return s.insertMessage(chatID, senderID, content, replyToId, attachmentIds, false, sentAt)
+}
+
+func (s *MemoryStore) NewSystemMessage(chatId, actorId int, content string, attachmentIds []int, sentAt time.Time) (int, error) {
+	return s.insertMessage(chatId, actorId, content, 0, attachmentIds, true, sentAt)
+}
+
+func (s *MemoryStore) insertMessage(chatID, senderID int, content string, replyToId int, attachmentIds []int, isSystem bool, sentAt time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.insertMessageLocked(chatID, senderID, content, replyToId, attachmentIds, isSystem, sentAt)
+}
+
+// insertMessageLocked is insertMessage for callers that hold mu.
+func (s *MemoryStore) insertMessageLocked(chatID, senderID int, content string, replyToId int, attachmentIds []int, isSystem bool, sentAt time.Time) (int, error) {
+	if _, ok := s.chats[chatID]; !ok {
+		return -1, errors.New("chat does not exist")
+	}
+
+	for _, attachmentId := range attachmentIds {
+		attachment, ok := s.attachments[attachmentId]
+		if !ok || attachment.UploaderID != senderID || attachment.MessageID != 0 {
+			return -1, ErrAttachmentNotFound
+		}
+	}
+
+	s.lastMessageId++
+	s.messages = append(s.messages, &memMessage{
+		id:        s.lastMessageId,
+		chatId:    chatID,
+		senderId:  senderID,
+		replyToId: replyToId,
+		content:   content,
+		isSystem:  isSystem,
+		sentAt:    sentAt,
+		hiddenFor: make(map[int]bool),
+	})
+
+	for _, attachmentId := range attachmentIds {
+		s.attachments[attachmentId].MessageID = s.lastMessageId
+		s.attachments[attachmentId].ChatID = chatID
+	}
+
+	return s.lastMessageId, nil
+}
+
+func (s *MemoryStore) GetChatMembers(chatId int) ([]int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	chat, ok := s.chats[chatId]
+	if !ok {
+		return nil, nil
+	}
+
+	members := make([]int, 0, len(chat.members))
+	for userId := range chat.members {
+		members = append(members, userId)
+	}
+	sort.Ints(members)
+
+	return members, nil
+}
+
+func (s *MemoryStore) AppendEvent(eventType string, data []byte, userIds []int) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastEventId++
+	event := &memEvent{
+		event: types.Event{
+			ID:        s.lastEventId,
+			Type:      eventType,
+			Data:      append([]byte{}, data...),
+			CreatedAt: time.Now(),
+		},
+		recipients: make(map[int]bool),
+	}
+	for _, userId := range userIds {
+		event.recipients[userId] = true
+	}
+	s.events = append(s.events, event)
+
+	return event.event.ID, nil
+}
+
+func (s *MemoryStore) GetEventsSince(userId int, since int64, limit int) ([]types.Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if since < s.prunedThrough {
+		return nil, ErrCursorExpired
+	}
+
+	var events []types.Event
+	for _, event := range s.events {
+		if len(events) >= limit {
+			break
+		}
+		if event.event.ID > since && event.recipients[userId] {
+			events = append(events, event.event)
+		}
+	}
+
+	return events, nil
+}
+
+func (s *MemoryStore) GetLatestEventID(userId int) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for i := len(s.events) - 1; i >= 0; i-- {
+		if s.events[i].recipients[userId] {
+			return s.events[i].event.ID, nil
+		}
+	}
+
+	return 0, nil
+}
+
+func (s *MemoryStore) PruneEvents(before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := 0
+	for deleted < len(s.events) && s.events[deleted].event.CreatedAt.Before(before) {
+		s.prunedThrough = s.events[deleted].event.ID
+		deleted++
+	}
+	s.events = s.events[deleted:]
+
+	return int64(deleted), nil
+}
+
+func (s *MemoryStore) GetChatMessages(userId, chatId, before, after, limit int) ([]types.ChatMessage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var matching []*memMessage
+	for _, m := range s.messages {
+		if m.chatId == chatId && (before == 0 || m.id < before) && m.id > after && !m.hiddenFor[userId] {
+			matching = append(matching, m)
+		}
+	}
+
+	// Keep the page closest to the cursor
+	if len(matching) > limit {
+		if after > 0 {
+			matching = matching[:limit]
+		} else {
+			matching = matching[len(matching)-limit:]
+		}
+	}
+
+	messages := []types.ChatMessage{}
+	for _, m := range matching {
+		message := s.chatMessage(m)
+		_, message.Blocked = s.blocks[userPair{userId, m.senderId}]
+		messages = append(messages, message)
+	}
+
+	return messages, nil
+}
+
+// chatMessage converts m for the API. The caller must hold mu.
+func (s *MemoryStore) chatMessage(m *memMessage) types.ChatMessage {
+	message := types.ChatMessage{
+		MessageID: m.id,
+		ChatID:    m.chatId,
+		SenderID:  m.senderId,
+		Content:   m.content,
+		IsSystem:  m.isSystem,
+		SentAt:    m.sentAt,
+		EditedAt:  m.editedAt,
+		DeletedAt: m.deletedAt,
+		Reactions: m.reactionSummaries(),
+	}
+	for _, attachment := range s.attachments {
+		if attachment.MessageID == m.id {
+			message.Attachments = append(message.Attachments, *attachment)
+		}
+	}
+	sort.Slice(message.Attachments, func(i, j int) bool { return message.Attachments[i].ID < message.Attachments[j].ID })
+	if profile, ok := s.profiles[m.senderId]; ok {
+		message.Sender = types.ProfileSnippet{ID: profile.ID, Name: profile.Name, Surname: profile.Surname, Pfp: profile.Pfp}
+	}
+	if quoted := s.findMessage(m.replyToId); quoted != nil {
+		message.ReplyTo = types.ChatMessage{
+			MessageID: quoted.id,
+			SenderID:  quoted.senderId,
+			Content:   quoted.content,
+			DeletedAt: quoted.deletedAt,
+		}.Preview()
+	}
+
+	return message
+}
+
+// findMessage returns the message with the given id or nil. The caller must hold mu.
+func (s *MemoryStore) findMessage(messageId int) *memMessage {
+	for _, m := range s.messages {
+		if m.id == messageId {
+			return m
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) GetMessage(messageId int) (types.ChatMessage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	m := s.findMessage(messageId)
+	if m == nil {
+		return types.ChatMessage{}, ErrMessageNotFound
+	}
+
+	return s.chatMessage(m), nil
+}
+
+func (s *MemoryStore) GetChatRole(userId, chatId int) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	chat, ok := s.chats[chatId]
+	if !ok || !chat.members[userId] {
+		return "", ErrNotInChat
+	}
+
+	return chat.roles[userId], nil
+}
+
+func (s *MemoryStore) EditMessage(messageId int, content string, editedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := s.findMessage(messageId)
+	if m == nil || m.deletedAt != nil {
+		return ErrMessageNotFound
+	}
+
+	previousAt := m.sentAt
+	if m.editedAt != nil {
+		previousAt = *m.editedAt
+	}
+	m.edits = append(m.edits, types.MessageEdit{Content: m.content, EditedAt: previousAt})
+	m.content = content
+	m.editedAt = &editedAt
+
+	return nil
+}
+
+func (s *MemoryStore) DeleteMessage(messageId int, deletedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := s.findMessage(messageId)
+	if m == nil || m.deletedAt != nil {
+		return ErrMessageNotFound
+	}
+
+	m.content = ""
+	m.edits = nil
+	m.reactions = nil
+	for id, attachment := range s.attachments {
+		if attachment.MessageID == m.id {
+			delete(s.attachments, id)
+			if chat := s.chats[m.chatId]; chat != nil && chat.avatarId == id {
+				chat.avatarId = 0
+			}
+		}
+	}
+	m.deletedAt = &deletedAt
+
+	return nil
+}
+
+func (s *MemoryStore) HideMessage(userId, messageId int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := s.findMessage(messageId)
+	if m == nil {
+		return ErrMessageNotFound
+	}
+
+	m.hiddenFor[userId] = true
+
+	return nil
+}
+
+func (s *MemoryStore) GetMessageEdits(messageId int) ([]types.MessageEdit, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	edits := []types.MessageEdit{}
+	if m := s.findMessage(messageId); m != nil {
+		edits = append(edits, m.edits...)
+	}
+
+	return edits, nil
+}
+
+func (s *MemoryStore) GetChatsInfo(userId int, beforeActivity time.Time, beforeChatId, limit int) ([]types.ChatShortInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	chats := []types.ChatShortInfo{}
+	for _, chat := range s.chats {
+		if !chat.members[userId] {
+			continue
+		}
+
+		info := types.ChatShortInfo{
+			ChatId:       chat.id,
+			CreatedAt:    chat.createdAt,
+			IsGroup:      chat.isGroup,
+			Name:         chat.name,
+			AvatarID:     chat.avatarId,
+			MemberCount:  len(chat.members),
+			LastActivity: chat.createdAt,
+		}
+
+		for _, m := range s.messages {
+			if m.chatId != chat.id || m.deletedAt != nil || m.hiddenFor[userId] {
+				continue
+			}
+			if m.senderId != userId && !m.isSystem && m.id > chat.lastRead[userId] {
+				info.UnreadCount++
+			}
+			info.LastMessageId = m.id
+			info.LastSenderId = m.senderId
+			info.Message = m.content
+			info.MessageIsSystem = m.isSystem
+			info.LastActivity = m.sentAt
+		}
+
+		if profile, ok := s.profiles[info.LastSenderId]; ok && info.LastMessageId != 0 {
+			info.LastSenderName = profile.Name
+			info.LastSenderSurname = profile.Surname
+			info.LastSenderPfp = profile.Pfp
+		}
+
+		if beforeChatId != 0 && !(info.LastActivity.Before(beforeActivity) ||
+			info.LastActivity.Equal(beforeActivity) && info.ChatId < beforeChatId) {
+			continue
+		}
+
+		chats = append(chats, info)
+	}
+
+	sort.Slice(chats, func(i, j int) bool {
+		if !chats[i].LastActivity.Equal(chats[j].LastActivity) {
+			return chats[i].LastActivity.After(chats[j].LastActivity)
+		}
+		return chats[i].ChatId > chats[j].ChatId
+	})
+
+	if len(chats) > limit {
+		chats = chats[:limit]
+	}
+
+	return chats, nil
+}
+
+func (s *MemoryStore) MarkRead(userId, chatId, messageId int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	chat, ok := s.chats[chatId]
+	if !ok || !chat.members[userId] {
+		return 0, ErrMessageNotFound
+	}
+
+	if m := s.findMessage(messageId); m == nil || m.chatId != chatId {
+		return 0, ErrMessageNotFound
+	}
+
+	if messageId > chat.lastRead[userId] {
+		chat.lastRead[userId] = messageId
+	}
+
+	return chat.lastRead[userId], nil
+}
+
+func (s *MemoryStore) GetReadCursors(chatId int) ([]types.ReadCursor, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	cursors := []types.ReadCursor{}
+	chat, ok := s.chats[chatId]
+	if !ok {
+		return cursors, nil
+	}
+
+	for userId := range chat.members {
+		cursors = append(cursors, types.ReadCursor{UserID: userId, LastReadMessageID: chat.lastRead[userId]})
+	}
+	sort.Slice(cursors, func(i, j int) bool { return cursors[i].UserID < cursors[j].UserID })
+
+	return cursors, nil
+}
+
+func (s *MemoryStore) GetMessageReplies(userId, messageId int) ([]types.ChatMessage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	replies := []types.ChatMessage{}
+	for _, m := range s.messages {
+		if m.replyToId == messageId && !m.hiddenFor[userId] {
+			reply := s.chatMessage(m)
+			_, reply.Blocked = s.blocks[userPair{userId, m.senderId}]
+			replies = append(replies, reply)
+		}
+	}
+
+	return replies, nil
+}
+
+// reactionSummaries aggregates m.reactions, each reaction ordered by when it was first used.
+func (m *memMessage) reactionSummaries() []types.ReactionSummary {
+	var summaries []types.ReactionSummary
+	for _, r := range m.reactions {
+		i := slices.IndexFunc(summaries, func(s types.ReactionSummary) bool { return s.Reaction == r.reaction })
+		if i == -1 {
+			summaries = append(summaries, types.ReactionSummary{Reaction: r.reaction})
+			i = len(summaries) - 1
+		}
+		summaries[i].Count++
+		summaries[i].UserIDs = append(summaries[i].UserIDs, r.userId)
+	}
+
+	return summaries
+}
+
+func (s *MemoryStore) AddReaction(userId, messageId int, reaction string, maxDistinct int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := s.findMessage(messageId)
+	if m == nil || m.deletedAt != nil {
+		return false, ErrMessageNotFound
+	}
+
+	if slices.Contains(m.reactions, memReaction{userId: userId, reaction: reaction}) {
+		return false, nil
+	}
+
+	summaries := m.reactionSummaries()
+	used := slices.ContainsFunc(summaries, func(s types.ReactionSummary) bool { return s.Reaction == reaction })
+	if maxDistinct > 0 && !used && len(summaries) >= maxDistinct {
+		return false, ErrTooManyReactions
+	}
+
+	m.reactions = append(m.reactions, memReaction{userId: userId, reaction: reaction})
+
+	return true, nil
+}
+
+func (s *MemoryStore) RemoveReaction(userId, messageId int, reaction string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := s.findMessage(messageId)
+	if m == nil {
+		return false, nil
+	}
+
+	i := slices.Index(m.reactions, memReaction{userId: userId, reaction: reaction})
+	if i == -1 {
+		return false, nil
+	}
+	m.reactions = slices.Delete(m.reactions, i, i+1)
+
+	return true, nil
+}
+
+func (s *MemoryStore) GetReactions(messageId int) ([]types.ReactionSummary, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	m := s.findMessage(messageId)
+	if m == nil || len(m.reactions) == 0 {
+		return []types.ReactionSummary{}, nil
+	}
+
+	return m.reactionSummaries(), nil
+}
+
+func (s *MemoryStore) CreateAttachment(attachment types.Attachment) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastAttachmentId++
+	attachment.ID = s.lastAttachmentId
+	attachment.MessageID = 0
+	attachment.ChatID = 0
+	s.attachments[attachment.ID] = &attachment
+
+	return attachment.ID, nil
+}
+
+func (s *MemoryStore) GetAttachment(attachmentId int) (types.Attachment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	attachment, ok := s.attachments[attachmentId]
+	if !ok {
+		return types.Attachment{}, ErrAttachmentNotFound
+	}
+
+	return *attachment, nil
+}
+
+func (s *MemoryStore) GetChat(chatId int) (types.Chat, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	chat, ok := s.chats[chatId]
+	if !ok {
+		return types.Chat{}, ErrChatNotFound
+	}
+
+	return types.Chat{ID: chat.id, CreatedAt: chat.createdAt, IsGroup: chat.isGroup, Name: chat.name, AvatarID: chat.avatarId}, nil
+}
+
+func (s *MemoryStore) AddChatMembers(chatId int, userIds []int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	chat, ok := s.chats[chatId]
+	if !ok {
+		return ErrChatNotFound
+	}
+
+	for _, userId := range userIds {
+		if _, ok := s.users[userId]; !ok {
+			return errors.New("user does not exist")
+		}
+	}
+
+	for _, userId := range userIds {
+		if !chat.members[userId] {
+			chat.members[userId] = true
+			chat.roles[userId] = types.ChatRoleMember
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) RemoveChatMember(chatId, userId int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	chat, ok := s.chats[chatId]
+	if !ok || !chat.members[userId] {
+		return 0, ErrNotInChat
+	}
+
+	role := chat.roles[userId]
+	delete(chat.members, userId)
+	delete(chat.roles, userId)
+	delete(chat.lastRead, userId)
+
+	if role != types.ChatRoleOwner || len(chat.members) == 0 {
+		return 0, nil
+	}
+
+	// Hand the chat to the first admin, or the first member without admins
+	newOwner := 0
+	for memberId := range chat.members {
+		isAdmin := chat.roles[memberId] == types.ChatRoleAdmin
+		wasAdmin := chat.roles[newOwner] == types.ChatRoleAdmin
+		if newOwner == 0 || isAdmin && !wasAdmin || isAdmin == wasAdmin && memberId < newOwner {
+			newOwner = memberId
+		}
+	}
+	chat.roles[newOwner] = types.ChatRoleOwner
+
+	return newOwner, nil
+}
+
+func (s *MemoryStore) SetChatRole(chatId, userId int, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	chat, ok := s.chats[chatId]
+	if !ok || !chat.members[userId] {
+		return ErrNotInChat
+	}
+
+	if role == types.ChatRoleOwner {
+		for memberId, memberRole := range chat.roles {
+			if memberRole == types.ChatRoleOwner {
+				chat.roles[memberId] = types.ChatRoleAdmin
+			}
+		}
+	}
+	chat.roles[userId] = role
+
+	return nil
+}
+
+func (s *MemoryStore) RenameChat(chatId int, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if chat, ok := s.chats[chatId]; ok {
+		chat.name = name
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) SetChatAvatar(chatId, actorId int, content string, attachmentId int, sentAt time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var attachmentIds []int
+	if attachmentId != 0 {
+		attachmentIds = []int{attachmentId}
+	}
+
+	messageId, err := s.insertMessageLocked(chatId, actorId, content, 0, attachmentIds, true, sentAt)
+	if err != nil {
+		return -1, err
+	}
+
+	s.chats[chatId].avatarId = attachmentId
+
+	return messageId, nil
+}
+
+func (s *MemoryStore) GetOrCreateDirectChat(user1, user2 int) (types.Chat, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := directKey(user1, user2)
+	for _, chat := range s.chats {
+		if chat.directKey == key {
+			return types.Chat{ID: chat.id, CreatedAt: chat.createdAt}, false, nil
+		}
+	}
+
+	for _, userId := range []int{user1, user2} {
+		if _, ok := s.users[userId]; !ok {
+			return types.Chat{}, false, errors.New("user does not exist")
+		}
+	}
+
+	s.lastChatId++
+	chat := &memChat{
+		id:        s.lastChatId,
+		createdAt: time.Now(),
+		members:   map[int]bool{user1: true, user2: true},
+		roles:     map[int]string{user1: types.ChatRoleMember, user2: types.ChatRoleMember},
+		lastRead:  make(map[int]int),
+		directKey: key,
+	}
+	s.chats[chat.id] = chat
+
+	return types.Chat{ID: chat.id, CreatedAt: chat.createdAt}, true, nil
+}
+
+func (s *MemoryStore) BlockUser(blockerId, blockedId int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.blocks[userPair{blockerId, blockedId}]; !ok {
+		s.blocks[userPair{blockerId, blockedId}] = time.Now()
+	}
+
+	delete(s.friends, userPair{blockerId, blockedId})
+	delete(s.friends, userPair{blockedId, blockerId})
+	delete(s.friendRequests, userPair{blockerId, blockedId})
+	delete(s.friendRequests, userPair{blockedId, blockerId})
+
+	return nil
+}
+
+func (s *MemoryStore) UnblockUser(blockerId, blockedId int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.blocks[userPair{blockerId, blockedId}]
+	delete(s.blocks, userPair{blockerId, blockedId})
+
+	return ok, nil
+}
+
+func (s *MemoryStore) IsBlocked(user1, user2 int) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok1 := s.blocks[userPair{user1, user2}]
+	_, ok2 := s.blocks[userPair{user2, user1}]
+
+	return ok1 || ok2, nil
+}
+
+func (s *MemoryStore) HasBlocked(blockerId, blockedId int) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.blocks[userPair{blockerId, blockedId}]
+	return ok, nil
+}
+
+func (s *MemoryStore) GetBlockedUsers(userId int) ([]int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	blocked := []int{}
+	for pair := range s.blocks {
+		if pair[0] == userId {
+			blocked = append(blocked, pair[1])
+		}
+	}
+	sort.Slice(blocked, func(i, j int) bool {
+		return s.blocks[userPair{userId, blocked[i]}].Before(s.blocks[userPair{userId, blocked[j]}])
+	})
+
+	return blocked, nil
+}
+
+func (s *MemoryStore) GetChatBlockers(chatId, userId int) ([]int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	blockers := []int{}
+	chat, ok := s.chats[chatId]
+	if !ok {
+		return blockers, nil
+	}
+
+	for memberId := range chat.members {
+		if _, ok := s.blocks[userPair{memberId, userId}]; ok {
+			blockers = append(blockers, memberId)
+		}
+	}
+	sort.Ints(blockers)
+
+	return blockers, nil
+}
+
+func (s *MemoryStore) RunScheduled(task string, interval time.Duration, fn func() error) (bool, error) {
+	s.tasksMu.Lock()
+	if s.tasksActive[task] || time.Since(s.taskRuns[task]) < interval {
+		s.tasksMu.Unlock()
+		return false, nil
+	}
+	s.tasksActive[task] = true
+	s.tasksMu.Unlock()
+
+	now := time.Now()
+	err := fn()
+
+	s.tasksMu.Lock()
+	delete(s.tasksActive, task)
+	if err == nil {
+		s.taskRuns[task] = now
+	}
+	s.tasksMu.Unlock()
+
+	return true, err
+}
+
+func (s *MemoryStore) PurgeSessions(before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := int64(0)
+	for tokenHash, session := range s.sessions {
+		if session.expiresAt.Before(before) || (!session.isValid && session.lastActive.Before(before)) {
+			delete(s.sessions, tokenHash)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+func (s *MemoryStore) ExpireFriendRequests(before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := int64(0)
+	for pair, sentAt := range s.friendRequests {
+		if sentAt.Before(before) {
+			delete(s.friendRequests, pair)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+func (s *MemoryStore) DeleteUnsentAttachments(before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := int64(0)
+	for id, attachment := range s.attachments {
+		if attachment.MessageID == 0 && attachment.CreatedAt.Before(before) {
+			delete(s.attachments, id)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+func (s *MemoryStore) GetReferencedFiles() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	paths := []string{}
+	for _, profile := range s.profiles {
+		if profile.Pfp != "" {
+			paths = append(paths, profile.Pfp)
+		}
+	}
+	for _, attachment := range s.attachments {
+		paths = append(paths, attachment.Path)
+	}
+
+	return paths, nil
+}
+
+func (s *MemoryStore) RecordAuthFailure(key string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.authFailures[key] = append(s.authFailures[key], at)
+	return nil
+}
+
+func (s *MemoryStore) GetAuthFailures(key string, since time.Time) (int, time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	count, last := 0, time.Time{}
+	for _, at := range s.authFailures[key] {
+		if at.Before(since) {
+			continue
+		}
+		count++
+		if at.After(last) {
+			last = at
+		}
+	}
+
+	return count, last, nil
+}
+
+func (s *MemoryStore) ResetAuthFailures(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.authFailures, key)
+	return nil
+}
+
+func (s *MemoryStore) PurgeAuthFailures(before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := int64(0)
+	for key, failures := range s.authFailures {
+		kept := failures[:0]
+		for _, at := range failures {
+			if at.Before(before) {
+				deleted++
+			} else {
+				kept = append(kept, at)
+			}
+		}
+
+		if len(kept) == 0 {
+			delete(s.authFailures, key)
+		} else {
+			s.authFailures[key] = kept
+		}
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,247 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/carson2222/social-app/types"
+)
+
+// chatMessageColumns selects a types.ChatMessage, see scanChatMessages.
+const chatMessageColumns = `messages.id, messages.chat_id, COALESCE(messages.sender_id, 0), messages.content, messages.is_system, messages.sent_at,
+messages.edited_at, messages.deleted_at,
+COALESCE(profiles.user_id, 0), COALESCE(profiles.name, ''), COALESCE(profiles.surname, ''), COALESCE(profiles.pfp, ''),
+COALESCE(quoted.id, 0), COALESCE(quoted.sender_id, 0), COALESCE(quoted.content, ''), quoted.deleted_at
+FROM messages LEFT JOIN profiles ON profiles.user_id = messages.sender_id
+LEFT JOIN messages quoted ON quoted.id = messages.reply_to_id`
+
+func scanChatMessages(rows *sql.Rows) ([]types.ChatMessage, error) {
+	defer rows.Close()
+
+	messages := []types.ChatMessage{}
+	for rows.Next() {
+		var m, quoted types.ChatMessage
+		err := rows.Scan(&m.MessageID, &m.ChatID, &m.SenderID, &m.Content, &m.IsSystem, &m.SentAt, &m.EditedAt, &m.DeletedAt,
+			&m.Sender.ID, &m.Sender.Name, &m.Sender.Surname, &m.Sender.Pfp,
+			&quoted.MessageID, &quoted.SenderID, &quoted.Content, &quoted.DeletedAt)
+		if err != nil {
+			return nil, err
+		}
+		if quoted.MessageID != 0 {
+			m.ReplyTo = quoted.Preview()
+		}
+		messages = append(messages, m)
+	}
+
+	return messages, rows.Err()
+}
+
+// GetChatMessages returns up to limit messages of the chat in ascending id
+// order, leaving out those the user deleted for themselves and flagging those
+// of senders the user blocked. With after > 0 it
+// returns the oldest messages newer than after; otherwise the newest messages
+// older than before (or the newest overall when before is 0).
+func (s *PostgresStore) GetChatMessages(userId, chatId, before, after, limit int) ([]types.ChatMessage, error) {
+	order := "DESC"
+	if after > 0 {
+		order = "ASC"
+	}
+
+	query := fmt.Sprintf(`SELECT %s
+WHERE messages.chat_id = $1 AND ($2 = 0 OR messages.id < $2) AND messages.id > $3
+AND NOT EXISTS (SELECT 1 FROM message_hidden WHERE message_hidden.message_id = messages.id AND message_hidden.user_id = $5)
+ORDER BY messages.id %s
+LIMIT $4;`, chatMessageColumns, order)
+
+	rows, err := s.db.Query(query, chatId, before, after, limit, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	messages, err := scanChatMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if after == 0 {
+		slices.Reverse(messages)
+	}
+
+	if err := s.loadMessageDetails(messages); err != nil {
+		return nil, err
+	}
+
+	if err := s.markBlocked(userId, messages); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// markBlocked flags the messages whose sender userId blocked.
+func (s *PostgresStore) markBlocked(userId int, messages []types.ChatMessage) error {
+	blocked, err := s.GetBlockedUsers(userId)
+	if err != nil {
+		return err
+	}
+
+	for i := range messages {
+		messages[i].Blocked = slices.Contains(blocked, messages[i].SenderID)
+	}
+
+	return nil
+}
+
+func (s *PostgresStore) GetMessage(messageId int) (types.ChatMessage, error) {
+	query := fmt.Sprintf(`SELECT %s WHERE messages.id = $1;`, chatMessageColumns)
+
+	rows, err := s.db.Query(query, messageId)
+	if err != nil {
+		return types.ChatMessage{}, err
+	}
+
+	messages, err := scanChatMessages(rows)
+	if err != nil {
+		return types.ChatMessage{}, err
+	}
+
+	if len(messages) == 0 {
+		return types.ChatMessage{}, ErrMessageNotFound
+	}
+
+	return messages[0], nil
+}
+
+// EditMessage replaces the content of a message and keeps the previous
+// content in its edit history.
+func (s *PostgresStore) EditMessage(messageId int, content string, editedAt time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query1 := `INSERT INTO message_edits (message_id, content, edited_at)
+SELECT id, content, COALESCE(edited_at, sent_at) FROM messages WHERE id = $1 AND deleted_at IS NULL;`
+
+	result, err := tx.Exec(query1, messageId)
+	if err != nil {
+		return err
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrMessageNotFound
+	}
+
+	query2 := `UPDATE messages SET content = $2, edited_at = $3 WHERE id = $1;`
+	if _, err := tx.Exec(query2, messageId, content, editedAt); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteMessage turns a message into a tombstone for everyone. Its content,
+// edit history, reactions and attachments are removed. Attachment files are
+// deleted later by the janitor.
+func (s *PostgresStore) DeleteMessage(messageId int, deletedAt time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query1 := `UPDATE messages SET content = '', deleted_at = $2 WHERE id = $1 AND deleted_at IS NULL;`
+
+	result, err := tx.Exec(query1, messageId, deletedAt)
+	if err != nil {
+		return err
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrMessageNotFound
+	}
+
+	query2 := `DELETE FROM message_edits WHERE message_id = $1;`
+	if _, err := tx.Exec(query2, messageId); err != nil {
+		return err
+	}
+
+	query3 := `DELETE FROM message_reactions WHERE message_id = $1;`
+	if _, err := tx.Exec(query3, messageId); err != nil {
+		return err
+	}
+
+	query4 := `DELETE FROM attachments WHERE message_id = $1;`
+	if _, err := tx.Exec(query4, messageId); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// HideMessage deletes a message for a single user.
+func (s *PostgresStore) HideMessage(userId, messageId int) error {
+	query := `INSERT INTO message_hidden (message_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING;`
+
+	_, err := s.db.Exec(query, messageId, userId)
+	return err
+}
+
+// GetMessageEdits returns the previous versions of a message, oldest first.
+func (s *PostgresStore) GetMessageEdits(messageId int) ([]types.MessageEdit, error) {
+	query := `SELECT content, edited_at FROM message_edits WHERE message_id = $1 ORDER BY id;`
+
+	rows, err := s.db.Query(query, messageId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	edits := []types.MessageEdit{}
+	for rows.Next() {
+		var edit types.MessageEdit
+		if err := rows.Scan(&edit.Content, &edit.EditedAt); err != nil {
+			return nil, err
+		}
+		edits = append(edits, edit)
+	}
+
+	return edits, rows.Err()
+}
+
+// GetMessageReplies returns the replies to a message in ascending id order,
+// leaving out those the user deleted for themselves and flagging those of
+// senders the user blocked.
+func (s *PostgresStore) GetMessageReplies(userId, messageId int) ([]types.ChatMessage, error) {
+	query := fmt.Sprintf(`SELECT %s
+WHERE messages.reply_to_id = $1
+AND NOT EXISTS (SELECT 1 FROM message_hidden WHERE message_hidden.message_id = messages.id AND message_hidden.user_id = $2)
+ORDER BY messages.id;`, chatMessageColumns)
+
+	rows, err := s.db.Query(query, messageId, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	replies, err := scanChatMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.loadMessageDetails(replies); err != nil {
+		return nil, err
+	}
+
+	if err := s.markBlocked(userId, replies); err != nil {
+		return nil, err
+	}
+
+	return replies, nil
+}
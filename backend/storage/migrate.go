@@ -0,0 +1,270 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockKey is the pg_advisory_lock key held while migrations run, so
+// that several instances booting at once apply each migration exactly once.
+const migrationLockKey = 7_364_152_902
+
+type migration struct {
+	version int
+	name    string
+	up      string
+	down    string
+}
+
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// loadMigrations reads migrations/NNNN_name.{up,down}.sql and returns them
+// sorted by version.
+func loadMigrations() ([]migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*migration)
+	for _, entry := range entries {
+		fileName := entry.Name()
+
+		base, direction, ok := strings.Cut(strings.TrimSuffix(fileName, ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("invalid migration file name: %s", fileName)
+		}
+
+		versionStr, name, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("invalid migration file name: %s", fileName)
+		}
+
+		version, err := strconv.Atoi(versionStr)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration version: %s", fileName)
+		}
+
+		content, err := migrationFiles.ReadFile(path.Join("migrations", fileName))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{version: version, name: name}
+			byVersion[version] = m
+		}
+		if m.name != name {
+			return nil, fmt.Errorf("migration %d has conflicting names: %s and %s", version, m.name, name)
+		}
+
+		if direction == "up" {
+			m.up = string(content)
+		} else {
+			m.down = string(content)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migration %04d_%s must have both up and down files", m.version, m.name)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+
+	return migrations, nil
+}
+
+// withMigrationLock runs fn on a dedicated connection holding the migration
+// advisory lock. Advisory locks belong to a session, so every statement must
+// go through the same connection.
+func (s *PostgresStore) withMigrationLock(fn func(conn *sql.Conn) error) error {
+	ctx := context.Background()
+
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1);`, migrationLockKey); err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+	defer conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1);`, migrationLockKey)
+
+	query := `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`
+	if _, err := conn.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	return fn(conn)
+}
+
+func appliedMigrations(conn *sql.Conn) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(context.Background(), `SELECT version, applied_at FROM schema_migrations;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+
+	return applied, rows.Err()
+}
+
+func runMigration(conn *sql.Conn, m migration, up bool) error {
+	ctx := context.Background()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if up {
+		if _, err := tx.ExecContext(ctx, m.up); err != nil {
+			return fmt.Errorf("migration %04d_%s up failed: %w", m.version, m.name, err)
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2);`, m.version, m.name); err != nil {
+			return err
+		}
+	} else {
+		if _, err := tx.ExecContext(ctx, m.down); err != nil {
+			return fmt.Errorf("migration %04d_%s down failed: %w", m.version, m.name, err)
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1;`, m.version); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// MigrateUp applies every pending migration and returns how many were applied.
+func (s *PostgresStore) MigrateUp() (int, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err = s.withMigrationLock(func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(conn)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range migrations {
+			if _, ok := applied[m.version]; ok {
+				continue
+			}
+
+			if err := runMigration(conn, m, true); err != nil {
+				return err
+			}
+			log.Printf("Applied migration %04d_%s\n", m.version, m.name)
+			count++
+		}
+
+		return nil
+	})
+
+	return count, err
+}
+
+// MigrateDown reverts the most recently applied migrations, at most steps of them.
+func (s *PostgresStore) MigrateDown(steps int) (int, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err = s.withMigrationLock(func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(migrations) - 1; i >= 0 && count < steps; i-- {
+			m := migrations[i]
+			if _, ok := applied[m.version]; !ok {
+				continue
+			}
+
+			if err := runMigration(conn, m, false); err != nil {
+				return err
+			}
+			log.Printf("Reverted migration %04d_%s\n", m.version, m.name)
+			count++
+		}
+
+		return nil
+	})
+
+	return count, err
+}
+
+func (s *PostgresStore) MigrationStatus() ([]MigrationStatus, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var status []MigrationStatus
+	err = s.withMigrationLock(func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(conn)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range migrations {
+			appliedAt, ok := applied[m.version]
+			status = append(status, MigrationStatus{
+				Version:   m.version,
+				Name:      m.name,
+				Applied:   ok,
+				AppliedAt: appliedAt,
+			})
+		}
+
+		return nil
+	})
+
+	return status, err
+}
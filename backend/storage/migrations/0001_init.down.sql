@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS messages;
+DROP TABLE IF EXISTS chat_users;
+DROP TABLE IF EXISTS chats;
+DROP TABLE IF EXISTS friend_requests;
+DROP TABLE IF EXISTS friends;
+DROP TABLE IF EXISTS profiles;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS users;
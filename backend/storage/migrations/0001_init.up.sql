@@ -0,0 +1,61 @@
+CREATE EXTENSION IF NOT EXISTS pgcrypto;
+
+CREATE TABLE IF NOT EXISTS users (
+	id SERIAL PRIMARY KEY,
+	email TEXT UNIQUE NOT NULL,
+	password TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER REFERENCES users (id) ON DELETE CASCADE NOT NULL,
+	session_token TEXT UNIQUE NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	expires_at TIMESTAMP NOT NULL DEFAULT (CURRENT_TIMESTAMP + INTERVAL '24 hours'),
+	last_active TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	is_valid BOOLEAN NOT NULL DEFAULT TRUE
+);
+
+CREATE TABLE IF NOT EXISTS profiles (
+	user_id INTEGER REFERENCES users (id) ON DELETE CASCADE NOT NULL,
+	name TEXT,
+	surname TEXT,
+	bio TEXT,
+	pfp TEXT
+);
+
+CREATE TABLE IF NOT EXISTS friends (
+	user_id INTEGER REFERENCES users (id) ON DELETE CASCADE,
+	friend_id INTEGER REFERENCES users (id) ON DELETE CASCADE,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (user_id, friend_id)
+);
+
+CREATE TABLE IF NOT EXISTS friend_requests (
+	sender_id INTEGER REFERENCES users (id) ON DELETE CASCADE NOT NULL,
+	receiver_id INTEGER REFERENCES users (id) ON DELETE CASCADE NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (sender_id, receiver_id)
+);
+
+CREATE TABLE IF NOT EXISTS chats (
+	id SERIAL PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	is_group BOOLEAN DEFAULT FALSE,
+	name TEXT
+);
+
+CREATE TABLE IF NOT EXISTS chat_users (
+	chat_id INTEGER REFERENCES chats (id) ON DELETE CASCADE,
+	user_id INTEGER REFERENCES users (id) ON DELETE CASCADE,
+	PRIMARY KEY (chat_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS messages (
+	id SERIAL PRIMARY KEY,
+	chat_id INTEGER REFERENCES chats (id) ON DELETE CASCADE,
+	sender_id INTEGER REFERENCES users (id) ON DELETE SET NULL,
+	content TEXT NOT NULL,
+	sent_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,6 @@
+-- Events too large for a NOTIFY payload are stored here and referenced by id.
+CREATE TABLE fanout_payloads (
+	id BIGSERIAL PRIMARY KEY,
+	payload TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS event_log_state;
+DROP TABLE IF EXISTS event_recipients;
+DROP TABLE IF EXISTS events;
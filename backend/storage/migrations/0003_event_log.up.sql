@@ -0,0 +1,24 @@
+-- Every broadcast event, kept for a while so reconnecting clients can replay
+-- what they missed. The event id is the cursor clients resume from.
+CREATE TABLE events (
+	id BIGSERIAL PRIMARY KEY,
+	type TEXT NOT NULL,
+	data JSONB NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE event_recipients (
+	user_id INTEGER REFERENCES users (id) ON DELETE CASCADE,
+	event_id BIGINT REFERENCES events (id) ON DELETE CASCADE,
+	PRIMARY KEY (user_id, event_id)
+);
+
+CREATE INDEX event_recipients_event_id_idx ON event_recipients (event_id);
+
+-- Highest event id removed by pruning; older cursors can not be replayed.
+CREATE TABLE event_log_state (
+	id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+	pruned_through BIGINT NOT NULL DEFAULT 0
+);
+
+INSERT INTO event_log_state DEFAULT VALUES;
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS messages_chat_id_id_idx;
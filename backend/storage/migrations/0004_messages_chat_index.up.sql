@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS messages_chat_id_id_idx ON messages (chat_id, id);
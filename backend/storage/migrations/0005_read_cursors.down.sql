@@ -0,0 +1 @@
+ALTER TABLE chat_users DROP COLUMN IF EXISTS last_read_message_id;
@@ -0,0 +1,2 @@
+-- Id of the newest message the member has read, 0 when nothing was read yet.
+ALTER TABLE chat_users ADD COLUMN last_read_message_id INTEGER NOT NULL DEFAULT 0;
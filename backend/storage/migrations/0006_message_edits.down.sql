@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS message_hidden;
+DROP TABLE IF EXISTS message_edits;
+ALTER TABLE messages DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE messages DROP COLUMN IF EXISTS edited_at;
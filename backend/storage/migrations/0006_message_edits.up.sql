@@ -0,0 +1,19 @@
+-- Deleted messages stay as tombstones so replies and cursors keep pointing somewhere.
+ALTER TABLE messages ADD COLUMN edited_at TIMESTAMP;
+ALTER TABLE messages ADD COLUMN deleted_at TIMESTAMP;
+
+-- Previous versions of edited messages.
+CREATE TABLE IF NOT EXISTS message_edits (
+	id SERIAL PRIMARY KEY,
+	message_id INTEGER REFERENCES messages (id) ON DELETE CASCADE NOT NULL,
+	content TEXT NOT NULL,
+	edited_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS message_edits_message_id_idx ON message_edits (message_id);
+
+-- Messages a user deleted for themselves only.
+CREATE TABLE IF NOT EXISTS message_hidden (
+	message_id INTEGER REFERENCES messages (id) ON DELETE CASCADE,
+	user_id INTEGER REFERENCES users (id) ON DELETE CASCADE,
+	PRIMARY KEY (user_id, message_id)
+);
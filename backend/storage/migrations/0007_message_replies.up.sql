@@ -0,0 +1,3 @@
+-- Message being replied to, replies outlive the quoted message.
+ALTER TABLE messages ADD COLUMN reply_to_id INTEGER REFERENCES messages (id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS messages_reply_to_id_idx ON messages (reply_to_id) WHERE reply_to_id IS NOT NULL;
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS message_reactions (
+	message_id INTEGER REFERENCES messages (id) ON DELETE CASCADE,
+	user_id INTEGER REFERENCES users (id) ON DELETE CASCADE,
+	reaction TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (message_id, user_id, reaction)
+);
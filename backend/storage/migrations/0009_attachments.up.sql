@@ -0,0 +1,14 @@
+-- Uploaded files, message_id stays NULL until the file is sent in a message.
+CREATE TABLE IF NOT EXISTS attachments (
+	id SERIAL PRIMARY KEY,
+	uploader_id INTEGER REFERENCES users (id) ON DELETE CASCADE NOT NULL,
+	message_id INTEGER REFERENCES messages (id) ON DELETE CASCADE,
+	path TEXT NOT NULL,
+	original_name TEXT NOT NULL,
+	mime_type TEXT NOT NULL,
+	size BIGINT NOT NULL,
+	width INTEGER NOT NULL DEFAULT 0,
+	height INTEGER NOT NULL DEFAULT 0,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS attachments_message_id_idx ON attachments (message_id);
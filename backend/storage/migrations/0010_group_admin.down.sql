@@ -0,0 +1,3 @@
+ALTER TABLE messages DROP COLUMN IF EXISTS is_system;
+ALTER TABLE chats DROP COLUMN IF EXISTS avatar_attachment_id;
+ALTER TABLE chat_users DROP COLUMN IF EXISTS role;
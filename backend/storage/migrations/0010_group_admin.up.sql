@@ -0,0 +1,26 @@
+-- Group creators own their chat, everyone else starts as a member. Databases
+-- migrated before roles moved here already have the column.
+ALTER TABLE chat_users ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'member'
+	CHECK (role IN ('owner', 'admin', 'member'));
+
+-- Group picture, an image attachment sent in the system message announcing it.
+ALTER TABLE chats ADD COLUMN avatar_attachment_id INTEGER REFERENCES attachments (id) ON DELETE SET NULL;
+
+-- System messages describe changes to the chat, their content is JSON.
+ALTER TABLE messages ADD COLUMN is_system BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Groups created before roles existed have no owner. The member who sent the
+-- first message is most likely the creator, the lowest user id breaks ties.
+UPDATE chat_users SET role = 'owner'
+FROM (
+	SELECT DISTINCT ON (chat_users.chat_id) chat_users.chat_id, chat_users.user_id
+	FROM chat_users
+	JOIN chats ON chats.id = chat_users.chat_id
+	LEFT JOIN messages ON messages.chat_id = chat_users.chat_id AND messages.sender_id = chat_users.user_id
+	WHERE chats.is_group AND NOT EXISTS (
+		SELECT 1 FROM chat_users owners WHERE owners.chat_id = chat_users.chat_id AND owners.role = 'owner'
+	)
+	GROUP BY chat_users.chat_id, chat_users.user_id
+	ORDER BY chat_users.chat_id, MIN(messages.id) NULLS LAST, chat_users.user_id
+) AS first_member
+WHERE chat_users.chat_id = first_member.chat_id AND chat_users.user_id = first_member.user_id;
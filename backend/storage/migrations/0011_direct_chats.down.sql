@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS chats_direct_key_idx;
+ALTER TABLE chats DROP COLUMN IF EXISTS direct_key;
@@ -0,0 +1,19 @@
+-- "<lower user id>:<higher user id>" for 1:1 chats, so each pair has at most one.
+ALTER TABLE chats ADD COLUMN direct_key TEXT;
+
+-- Existing duplicates keep their messages, only the oldest chat of a pair is reused.
+UPDATE chats SET direct_key = pairs.direct_key
+FROM (
+	SELECT DISTINCT ON (direct_key) chat_id, direct_key
+	FROM (
+		SELECT chat_users.chat_id, MIN(chat_users.user_id) || ':' || MAX(chat_users.user_id) AS direct_key
+		FROM chat_users JOIN chats ON chats.id = chat_users.chat_id
+		WHERE chats.is_group IS NOT TRUE
+		GROUP BY chat_users.chat_id
+		HAVING COUNT(*) = 2
+	) direct
+	ORDER BY direct_key, chat_id
+) pairs
+WHERE chats.id = pairs.chat_id;
+
+CREATE UNIQUE INDEX IF NOT EXISTS chats_direct_key_idx ON chats (direct_key);
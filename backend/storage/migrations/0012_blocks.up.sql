@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS blocks (
+	blocker_id INTEGER REFERENCES users (id) ON DELETE CASCADE,
+	blocked_id INTEGER REFERENCES users (id) ON DELETE CASCADE,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (blocker_id, blocked_id)
+);
+CREATE INDEX IF NOT EXISTS blocks_blocked_id_idx ON blocks (blocked_id);
@@ -0,0 +1,3 @@
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS user_agent TEXT NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS ip TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions (user_id);
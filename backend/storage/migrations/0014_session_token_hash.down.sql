@@ -0,0 +1,7 @@
+-- Plaintext tokens can't be recovered, so every session is signed out.
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS session_token TEXT;
+UPDATE sessions SET session_token = token_hash, is_valid = false;
+ALTER TABLE sessions ALTER COLUMN session_token SET NOT NULL;
+ALTER TABLE sessions ADD CONSTRAINT sessions_session_token_key UNIQUE (session_token);
+DROP INDEX IF EXISTS sessions_token_hash_idx;
+ALTER TABLE sessions DROP COLUMN IF EXISTS token_hash;
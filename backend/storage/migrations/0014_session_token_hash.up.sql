@@ -0,0 +1,7 @@
+-- Tokens are only stored as their SHA-256 hash. Existing tokens keep working:
+-- they are hashed here and the presented token is hashed on lookup.
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS token_hash TEXT;
+UPDATE sessions SET token_hash = encode(digest(session_token, 'sha256'), 'hex') WHERE token_hash IS NULL;
+ALTER TABLE sessions ALTER COLUMN token_hash SET NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS sessions_token_hash_idx ON sessions (token_hash);
+ALTER TABLE sessions DROP COLUMN IF EXISTS session_token;
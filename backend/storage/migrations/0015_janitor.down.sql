@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS friend_requests_created_at_idx;
+DROP INDEX IF EXISTS sessions_expires_at_idx;
+DROP TABLE IF EXISTS janitor_runs;
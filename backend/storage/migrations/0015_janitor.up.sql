@@ -0,0 +1,7 @@
+-- Last run of each janitor task, shared by every instance.
+CREATE TABLE IF NOT EXISTS janitor_runs (
+	task TEXT PRIMARY KEY,
+	last_run TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS sessions_expires_at_idx ON sessions (expires_at);
+CREATE INDEX IF NOT EXISTS friend_requests_created_at_idx ON friend_requests (created_at);
@@ -0,0 +1,8 @@
+-- Failed login and register attempts, keyed by client IP or email.
+CREATE TABLE IF NOT EXISTS auth_attempts (
+	id BIGSERIAL PRIMARY KEY,
+	key TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS auth_attempts_key_idx ON auth_attempts (key, created_at);
+CREATE INDEX IF NOT EXISTS auth_attempts_created_at_idx ON auth_attempts (created_at);
@@ -0,0 +1,10 @@
+-- Single-use password reset tokens, stored only as their SHA-256 hash.
+CREATE TABLE IF NOT EXISTS password_resets (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER REFERENCES users (id) ON DELETE CASCADE NOT NULL,
+	token_hash TEXT UNIQUE NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	expires_at TIMESTAMP NOT NULL,
+	used_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS password_resets_user_id_idx ON password_resets (user_id);
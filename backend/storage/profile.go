@@ -2,20 +2,6 @@ package storage
 
 import "github.com/carson2222/social-app/types"
 
-func (s *PostgresStore) createProfilesTable() error {
-
-	query := `CREATE TABLE IF NOT EXISTS profiles (
-		user_id INTEGER REFERENCES users (id) ON DELETE CASCADE NOT NULL,
-		name TEXT,
-		surname TEXT,
-		bio TEXT,
-		pfp TEXT
-	)`
-
-	_, err := s.db.Exec(query)
-	return err
-}
-
 func (s *PostgresStore) InitProfile(id int) error {
 	query := `INSERT INTO profiles (user_id) VALUES ($1);`
 
@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/carson2222/social-app/types"
+	"github.com/lib/pq"
+)
+
+// AddReaction adds the user's reaction to a message and reports whether it was
+// new. A reaction nobody used on the message yet fails with
+// ErrTooManyReactions once the message has maxDistinct reactions (0 means no
+// limit).
+func (s *PostgresStore) AddReaction(userId, messageId int, reaction string, maxDistinct int) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// Lock the message so concurrent reactions can not exceed the limit
+	query1 := `SELECT id FROM messages WHERE id = $1 AND deleted_at IS NULL FOR UPDATE;`
+	if err := tx.QueryRow(query1, messageId).Scan(&messageId); errors.Is(err, sql.ErrNoRows) {
+		return false, ErrMessageNotFound
+	} else if err != nil {
+		return false, err
+	}
+
+	if maxDistinct > 0 {
+		query2 := `SELECT COUNT(DISTINCT reaction), COUNT(*) FILTER (WHERE reaction = $2)
+FROM message_reactions WHERE message_id = $1;`
+
+		var distinct, used int
+		if err := tx.QueryRow(query2, messageId, reaction).Scan(&distinct, &used); err != nil {
+			return false, err
+		}
+
+		if used == 0 && distinct >= maxDistinct {
+			return false, ErrTooManyReactions
+		}
+	}
+
+	query3 := `INSERT INTO message_reactions (message_id, user_id, reaction) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING;`
+	result, err := tx.Exec(query3, messageId, userId, reaction)
+	if err != nil {
+		return false, err
+	}
+
+	added, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return added > 0, tx.Commit()
+}
+
+// RemoveReaction removes the user's reaction and reports whether there was one.
+func (s *PostgresStore) RemoveReaction(userId, messageId int, reaction string) (bool, error) {
+	query := `DELETE FROM message_reactions WHERE message_id = $1 AND user_id = $2 AND reaction = $3;`
+
+	result, err := s.db.Exec(query, messageId, userId, reaction)
+	if err != nil {
+		return false, err
+	}
+
+	removed, err := result.RowsAffected()
+	return removed > 0, err
+}
+
+func (s *PostgresStore) GetReactions(messageId int) ([]types.ReactionSummary, error) {
+	reactions, err := s.getReactions([]int{messageId})
+	if err != nil {
+		return nil, err
+	}
+
+	if reactions[messageId] == nil {
+		return []types.ReactionSummary{}, nil
+	}
+
+	return reactions[messageId], nil
+}
+
+// getReactions returns the reaction summaries of the given messages by message
+// id, each reaction ordered by when it was first used.
+func (s *PostgresStore) getReactions(messageIds []int) (map[int][]types.ReactionSummary, error) {
+	query := `SELECT message_id, reaction, array_agg(user_id ORDER BY created_at, user_id)
+FROM message_reactions WHERE message_id = ANY($1)
+GROUP BY message_id, reaction
+ORDER BY message_id, MIN(created_at), reaction;`
+
+	rows, err := s.db.Query(query, pq.Array(messageIds))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reactions := make(map[int][]types.ReactionSummary)
+	for rows.Next() {
+		var messageId int
+		var summary types.ReactionSummary
+		var userIds pq.Int64Array
+		if err := rows.Scan(&messageId, &summary.Reaction, &userIds); err != nil {
+			return nil, err
+		}
+
+		for _, userId := range userIds {
+			summary.UserIDs = append(summary.UserIDs, int(userId))
+		}
+		summary.Count = len(summary.UserIDs)
+		reactions[messageId] = append(reactions[messageId], summary)
+	}
+
+	return reactions, rows.Err()
+}
+
+// loadMessageDetails fills in the reactions and attachments of messages.
+func (s *PostgresStore) loadMessageDetails(messages []types.ChatMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	messageIds := make([]int, len(messages))
+	for i, m := range messages {
+		messageIds[i] = m.MessageID
+	}
+
+	reactions, err := s.getReactions(messageIds)
+	if err != nil {
+		return err
+	}
+
+	attachments, err := s.getAttachments(messageIds)
+	if err != nil {
+		return err
+	}
+
+	for i := range messages {
+		messages[i].Reactions = reactions[messages[i].MessageID]
+		messages[i].Attachments = attachments[messages[i].MessageID]
+	}
+
+	return nil
+}
@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/carson2222/social-app/types"
+)
+
+// MarkRead moves the member's read cursor forward to messageId and returns the
+// resulting cursor, which stays unchanged if it was already further.
+func (s *PostgresStore) MarkRead(userId, chatId, messageId int) (int, error) {
+	query := `UPDATE chat_users SET last_read_message_id = GREATEST(last_read_message_id, $3)
+WHERE chat_id = $1 AND user_id = $2 AND EXISTS (SELECT 1 FROM messages WHERE id = $3 AND chat_id = $1)
+RETURNING last_read_message_id;`
+
+	var lastRead int
+	err := s.db.QueryRow(query, chatId, userId, messageId).Scan(&lastRead)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrMessageNotFound
+	}
+
+	return lastRead, err
+}
+
+func (s *PostgresStore) GetReadCursors(chatId int) ([]types.ReadCursor, error) {
+	query := `SELECT user_id, last_read_message_id FROM chat_users WHERE chat_id = $1 ORDER BY user_id;`
+
+	rows, err := s.db.Query(query, chatId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cursors := []types.ReadCursor{}
+	for rows.Next() {
+		var cursor types.ReadCursor
+		if err := rows.Scan(&cursor.UserID, &cursor.LastReadMessageID); err != nil {
+			return nil, err
+		}
+		cursors = append(cursors, cursor)
+	}
+
+	return cursors, rows.Err()
+}
@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// CreatePasswordReset stores a reset token for the user, replacing any unused
+// one, so only the latest reset email works.
+func (s *PostgresStore) CreatePasswordReset(userId int, tokenHash string, expiresAt time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query1 := `DELETE FROM password_resets WHERE user_id = $1 AND used_at IS NULL;`
+	if _, err := tx.Exec(query1, userId); err != nil {
+		return err
+	}
+
+	query2 := `INSERT INTO password_resets (user_id, token_hash, expires_at) VALUES ($1, $2, $3);`
+	if _, err := tx.Exec(query2, userId, tokenHash, expiresAt); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ResetPassword uses up a reset token and sets the password of its user, whose
+// id it returns. It fails with ErrResetTokenInvalid if the token does not
+// exist, expired or was already used.
+func (s *PostgresStore) ResetPassword(tokenHash, password string) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return -1, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	query1 := `UPDATE password_resets SET used_at = $2
+WHERE token_hash = $1 AND used_at IS NULL AND expires_at > $2
+RETURNING user_id;`
+
+	userId := -1
+	err = tx.QueryRow(query1, tokenHash, now).Scan(&userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return -1, ErrResetTokenInvalid
+	}
+	if err != nil {
+		return -1, err
+	}
+
+	query2 := `UPDATE users SET password = crypt($2, gen_salt('bf')) WHERE id = $1;`
+	if _, err := tx.Exec(query2, userId, password); err != nil {
+		return -1, err
+	}
+
+	return userId, tx.Commit()
+}
@@ -1,36 +1,43 @@
 package storage
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
-	"fmt"
 	"time"
+
+	"github.com/carson2222/social-app/config"
+	"github.com/carson2222/social-app/types"
 )
 
-func (s *PostgresStore) createSessionsTable() error {
-	SESSION_DURATION := 24 // Hours
-
-	query := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS sessions (
-		id SERIAL PRIMARY KEY,
-		user_id INTEGER REFERENCES users (id) ON DELETE CASCADE NOT NULL,
-		session_token TEXT UNIQUE NOT NULL,
-		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-		expires_at TIMESTAMP NOT NULL DEFAULT (CURRENT_TIMESTAMP + INTERVAL '%d hours'),
-		last_active TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-		is_valid BOOLEAN NOT NULL DEFAULT TRUE
-	)`, SESSION_DURATION)
-
-	_, err := s.db.Exec(query)
-	return err
+const sessionColumns = `id, user_id, user_agent, ip, created_at, last_active, expires_at`
+
+// NewToken returns a random, opaque token for a session or a one-time link.
+func NewToken() (string, error) {
+	randomBytes := make([]byte, 32)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(randomBytes), nil
 }
 
-func (s *PostgresStore) CreateSession(user_id int) (string, error) {
+// HashToken returns the form a token is stored in, so a leaked database
+// doesn't leak usable tokens.
+func HashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
 
-	query := `INSERT INTO sessions (user_id, session_token)
-VALUES ($1, encode($2::text::bytea, 'hex') || encode(gen_random_bytes(32), 'hex')) RETURNING session_token;`
+func (s *PostgresStore) CreateSession(user_id int, userAgent, ip string) (string, error) {
+	sessionToken, err := NewToken()
+	if err != nil {
+		return "", err
+	}
 
-	var sessionToken string
-	err := s.db.QueryRow(query, user_id, user_id).Scan(&sessionToken)
+	query := `INSERT INTO sessions (user_id, token_hash, expires_at, user_agent, ip) VALUES ($1, $2, $3, $4, $5);`
 
+	_, err = s.db.Exec(query, user_id, HashToken(sessionToken), time.Now().Add(s.config.Session.Duration), userAgent, ip)
 	if err != nil {
 		return "", err
 	}
@@ -38,39 +45,138 @@ VALUES ($1, encode($2::text::bytea, 'hex') || encode(gen_random_bytes(32), 'hex'
 	return sessionToken, nil
 }
 
-func (s *PostgresStore) VerifySession(sessionToken string) (bool, int, error) {
+// VerifySession returns the session of a valid token and records the activity,
+// extending the session if sliding expiry is enabled.
+func (s *PostgresStore) VerifySession(sessionToken string) (types.Session, error) {
 
-	query := `SELECT is_valid, user_id, expires_at FROM sessions WHERE session_token = $1;`
+	query := `SELECT ` + sessionColumns + `, is_valid FROM sessions WHERE token_hash = $1;`
 
+	var session types.Session
 	isValid := false
-	expiresAt := time.Now()
-	userId := -1
 
-	err := s.db.QueryRow(query, sessionToken).Scan(&isValid, &userId, &expiresAt)
+	err := s.db.QueryRow(query, HashToken(sessionToken)).Scan(&session.ID, &session.UserID, &session.UserAgent, &session.IP,
+		&session.CreatedAt, &session.LastActive, &session.ExpiresAt, &isValid)
 	if err != nil {
-		return false, -1, err
+		return types.Session{}, err
 	}
 
-	if time.Now().After(expiresAt) {
-		isValid = false
-		err = errors.New("session expired")
+	now := time.Now()
+	if now.After(session.ExpiresAt) {
+		return types.Session{}, errors.New("session expired")
 	}
 
 	if !isValid {
-		err = errors.New("session invalid")
+		return types.Session{}, errors.New("session invalid")
+	}
+
+	if !touchDue(s.config.Session, session, now) {
+		return session, nil
 	}
 
-	return isValid, userId, err
+	session.LastActive = now
+	if !s.config.Session.Sliding {
+		query = `UPDATE sessions SET last_active = $2 WHERE id = $1;`
+		if _, err := s.db.Exec(query, session.ID, session.LastActive); err != nil {
+			return types.Session{}, err
+		}
+		return session, nil
+	}
+
+	session.ExpiresAt = slideExpiry(s.config.Session, session, now)
+
+	query = `UPDATE sessions SET last_active = $2, expires_at = $3 WHERE id = $1;`
+	if _, err := s.db.Exec(query, session.ID, session.LastActive, session.ExpiresAt); err != nil {
+		return types.Session{}, err
+	}
+
+	return session, nil
 }
 
 func (s *PostgresStore) KillSession(sessionToken string) error {
 
-	query := `UPDATE sessions SET is_valid = false WHERE session_token = $1;`
+	query := `UPDATE sessions SET is_valid = false WHERE token_hash = $1;`
 
-	_, err := s.db.Exec(query, sessionToken)
+	_, err := s.db.Exec(query, HashToken(sessionToken))
 	if err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// GetSessions returns the user's active sessions, most recently used first.
+func (s *PostgresStore) GetSessions(userId int) ([]types.Session, error) {
+	query := `SELECT ` + sessionColumns + ` FROM sessions
+WHERE user_id = $1 AND is_valid AND expires_at > $2
+ORDER BY last_active DESC, id DESC;`
+
+	rows, err := s.db.Query(query, userId, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []types.Session{}
+	for rows.Next() {
+		var session types.Session
+		if err := rows.Scan(&session.ID, &session.UserID, &session.UserAgent, &session.IP,
+			&session.CreatedAt, &session.LastActive, &session.ExpiresAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// RevokeSession invalidates one of the user's sessions and reports whether it
+// was still active.
+func (s *PostgresStore) RevokeSession(userId, sessionId int) (bool, error) {
+	query := `UPDATE sessions SET is_valid = false
+WHERE id = $1 AND user_id = $2 AND is_valid AND expires_at > $3;`
+
+	result, err := s.db.Exec(query, sessionId, userId, time.Now())
+	if err != nil {
+		return false, err
+	}
+
+	revoked, err := result.RowsAffected()
+	return revoked > 0, err
+}
+
+// RevokeOtherSessions invalidates every active session of the user except
+// keepSessionId and returns the revoked session IDs.
+func (s *PostgresStore) RevokeOtherSessions(userId, keepSessionId int) ([]int, error) {
+	query := `UPDATE sessions SET is_valid = false
+WHERE user_id = $1 AND id <> $2 AND is_valid AND expires_at > $3
+RETURNING id;`
+
+	return s.queryIds(query, userId, keepSessionId, time.Now())
+}
+
+// touchDue reports whether the last activity of a session used at now is old
+// enough to be written again, so busy sessions don't cost a write per request.
+func touchDue(cfg config.SessionConfig, session types.Session, now time.Time) bool {
+	return now.Sub(session.LastActive) >= cfg.TouchInterval
+}
+
+// slideExpiry returns the expiry of a session used at now. Without sliding
+// expiry it is unchanged, otherwise it moves to one session duration after now,
+// capped at the session's max lifetime.
+func slideExpiry(cfg config.SessionConfig, session types.Session, now time.Time) time.Time {
+	if !cfg.Sliding {
+		return session.ExpiresAt
+	}
+
+	expiresAt := now.Add(cfg.Duration)
+	if cfg.MaxLifetime > 0 {
+		if limit := session.CreatedAt.Add(cfg.MaxLifetime); expiresAt.After(limit) {
+			expiresAt = limit
+		}
+	}
+
+	if expiresAt.Before(session.ExpiresAt) {
+		return session.ExpiresAt
+	}
+	return expiresAt
+}
@@ -2,74 +2,170 @@ package storage
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"time"
 
+	"github.com/carson2222/social-app/config"
+	"github.com/carson2222/social-app/types"
 	_ "github.com/lib/pq"
 )
 
-//	type Storage interface {
-//		createUser(*Credentials) (int, error)
-//		authUser(*Credentials) (int, error)
-//		createSession(int) (string, error)
-//		verifySession(string) (bool, int, error)
-//		killSession(string) error
-//		initProfile(int) error
-//		updateProfile(int, string, string, string, string) error
-//		getProfileByID(int) (Profile, error)
-//		areFriends(userId int, friendId int) (bool, error)
-//		addFriend(senderId int, receiverId int) error
-//		isRequestedFriend(senderId int, receiverId int) (bool, error)
-//		acceptFriendRequest(userId int, senderId int) error
-//		rejectFriendRequest(userId int, senderId int) error
-//		removeFriend(userId int, friendId int) error
-//	}
+// Storage is the persistence layer used by the API and WebSocket servers.
+type Storage interface {
+	CreateUser(c *types.Credentials) (int, error)
+	AuthUser(c *types.Credentials) (int, error)
+	IsUserExisting(id int) (bool, error)
+	GetUserIDByEmail(email string) (int, error)
+	CheckPassword(userId int, password string) (bool, error)
+	SetPassword(userId int, password string) error
+	CreatePasswordReset(userId int, tokenHash string, expiresAt time.Time) error
+	ResetPassword(tokenHash, password string) (int, error)
+
+	CreateSession(userId int, userAgent, ip string) (string, error)
+	VerifySession(sessionToken string) (types.Session, error)
+	KillSession(sessionToken string) error
+	GetSessions(userId int) ([]types.Session, error)
+	RevokeSession(userId, sessionId int) (bool, error)
+	RevokeOtherSessions(userId, keepSessionId int) ([]int, error)
+
+	InitProfile(id int) error
+	UpdateProfile(id int, name, surname, bio, pfp string) error
+	GetProfileByID(id int) (types.Profile, error)
+
+	AreFriends(userId, friendId int) (bool, error)
+	IsRequestedFriend(senderId, receiverId int) (bool, error)
+	GetFriends(userId int) (map[int]bool, error)
+	SendFR(senderId, receiverId int) error
+	AcceptFriendRequest(userId, senderId int) error
+	RejectFriendRequest(userId, senderId int) error
+	RemoveFriend(userId, friendId int) error
+
+	BlockUser(blockerId, blockedId int) error
+	UnblockUser(blockerId, blockedId int) (bool, error)
+	IsBlocked(user1, user2 int) (bool, error)
+	HasBlocked(blockerId, blockedId int) (bool, error)
+	GetBlockedUsers(userId int) ([]int, error)
+	GetChatBlockers(chatId, userId int) ([]int, error)
+
+	GetUserChats(userId int) (map[int]bool, error)
+	GetChatMembers(chatId int) ([]int, error)
+	IsUserInChat(userId, chatId int) error
+	InitNewChat(chatName string, members []int) (int, error)
+	GetOrCreateDirectChat(user1, user2 int) (types.Chat, bool, error)
+	NewMessage(chatID, senderID int, content string, replyToId int, attachmentIds []int, sentAt time.Time) (int, error)
+	GetChatMessages(userId, chatId, before, after, limit int) ([]types.ChatMessage, error)
+	GetMessage(messageId int) (types.ChatMessage, error)
+	GetChatRole(userId, chatId int) (string, error)
+	GetChat(chatId int) (types.Chat, error)
+	AddChatMembers(chatId int, userIds []int) error
+	RemoveChatMember(chatId, userId int) (int, error)
+	SetChatRole(chatId, userId int, role string) error
+	RenameChat(chatId int, name string) error
+	SetChatAvatar(chatId, actorId int, content string, attachmentId int, sentAt time.Time) (int, error)
+	NewSystemMessage(chatId, actorId int, content string, attachmentIds []int, sentAt time.Time) (int, error)
+	EditMessage(messageId int, content string, editedAt time.Time) error
+	DeleteMessage(messageId int, deletedAt time.Time) error
+	HideMessage(userId, messageId int) error
+	GetMessageEdits(messageId int) ([]types.MessageEdit, error)
+	GetMessageReplies(userId, messageId int) ([]types.ChatMessage, error)
+	AddReaction(userId, messageId int, reaction string, maxDistinct int) (bool, error)
+	RemoveReaction(userId, messageId int, reaction string) (bool, error)
+	GetReactions(messageId int) ([]types.ReactionSummary, error)
+	CreateAttachment(attachment types.Attachment) (int, error)
+	GetAttachment(attachmentId int) (types.Attachment, error)
+	GetChatsInfo(userId int, beforeActivity time.Time, beforeChatId, limit int) ([]types.ChatShortInfo, error)
+	MarkRead(userId, chatId, messageId int) (int, error)
+	GetReadCursors(chatId int) ([]types.ReadCursor, error)
+
+	AppendEvent(eventType string, data []byte, userIds []int) (int64, error)
+	GetEventsSince(userId int, since int64, limit int) ([]types.Event, error)
+	GetLatestEventID(userId int) (int64, error)
+	PruneEvents(before time.Time) (int64, error)
+
+	RunScheduled(task string, interval time.Duration, fn func() error) (bool, error)
+	PurgeSessions(before time.Time) (int64, error)
+	ExpireFriendRequests(before time.Time) (int64, error)
+	DeleteUnsentAttachments(before time.Time) (int64, error)
+	GetReferencedFiles() ([]string, error)
+
+	RecordAuthFailure(key string, at time.Time) error
+	GetAuthFailures(key string, since time.Time) (int, time.Time, error)
+	ResetAuthFailures(key string) error
+	PurgeAuthFailures(before time.Time) (int64, error)
+}
+
+var (
+	_ Storage = (*PostgresStore)(nil)
+	_ Storage = (*MemoryStore)(nil)
+)
+
+var (
+	// ErrNotInChat is returned by IsUserInChat when the user is not a member of the chat.
+	ErrNotInChat = errors.New("user is not a member of the chat")
+	// ErrUserNotFound is returned when a user does not exist.
+	ErrUserNotFound = errors.New("user not found")
+	// ErrResetTokenInvalid is returned by ResetPassword when the token does
+	// not exist, expired or was already used.
+	ErrResetTokenInvalid = errors.New("password reset token is invalid or expired")
+	// ErrChatNotFound is returned when a chat does not exist.
+	ErrChatNotFound = errors.New("chat not found")
+	// ErrMessageNotFound is returned when a message does not exist (in the given chat).
+	ErrMessageNotFound = errors.New("message not found")
+	// ErrTooManyReactions is returned by AddReaction when a message already
+	// has the maximum number of distinct reactions.
+	ErrTooManyReactions = errors.New("message has too many distinct reactions")
+	// ErrAttachmentNotFound is returned when an attachment does not exist or,
+	// when sending it, was uploaded by someone else or already sent.
+	ErrAttachmentNotFound = errors.New("attachment not found")
+)
 
 type PostgresStore struct {
-	db *sql.DB
+	db     *sql.DB
+	config *config.Config
 }
 
-func NewPostgresStorage() (*PostgresStore, error) {
-	connStr := "user=admin dbname=postgres password=admin sslmode=disable"
-	db, err := sql.Open("postgres", connStr)
+func NewPostgresStorage(cfg *config.Config) (*PostgresStore, error) {
+	db, err := sql.Open("postgres", cfg.Database.ConnString())
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
 
 	if err = db.Ping(); err != nil {
-		return nil, fmt.Errorf("failed to pin	g database: %w", err)
+		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	return &PostgresStore{db: db}, nil
+	return &PostgresStore{db: db, config: cfg}, nil
 }
 
+// Init brings the database schema up to date.
 func (s *PostgresStore) Init() error {
-
-	tasks := []func() error{
-		s.createExtensions,
-		s.createUsersTable,
-		s.createSessionsTable,
-		s.createProfilesTable,
-		s.createFriendsTable,
-		s.createFriendRequestsTable,
-		s.createChatsTable,
-		s.createChatUsersTable,
-		s.createMessagesTable,
-	}
-
-	for _, task := range tasks {
-		if err := task(); err != nil {
-			return err
-		}
+	if _, err := s.MigrateUp(); err != nil {
+		return fmt.Errorf("failed to migrate database: %w", err)
 	}
 
 	log.Println("Storage initialized")
 	return nil
 }
 
-func (s *PostgresStore) createExtensions() error {
-	_, err := s.db.Exec("CREATE EXTENSION IF NOT EXISTS pgcrypto;")
+// queryIds runs a query that selects a single integer column.
+func (s *PostgresStore) queryIds(query string, args ...any) ([]int, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
 
-	return err
+	return ids, rows.Err()
 }
@@ -1,6 +1,11 @@
 package storage
 
-import "github.com/carson2222/social-app/types"
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/carson2222/social-app/types"
+)
 
 func (s *PostgresStore) AuthUser(c *types.Credentials) (int, error) {
 	query := `SELECT id FROM users WHERE email = $1 AND password = crypt($2, password) LIMIT 1;`
@@ -15,18 +20,6 @@ func (s *PostgresStore) AuthUser(c *types.Credentials) (int, error) {
 	return ID, nil
 }
 
-func (s *PostgresStore) createUsersTable() error {
-	query := `CREATE TABLE IF NOT EXISTS users (
-		id SERIAL PRIMARY KEY,
-		email TEXT UNIQUE NOT NULL,
-		password TEXT NOT NULL,
-		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
-	)`
-
-	_, err := s.db.Exec(query)
-	return err
-}
-
 func (s *PostgresStore) CreateUser(c *types.Credentials) (int, error) {
 	query := `INSERT INTO users (email, password) VALUES ($1, crypt($2, gen_salt('bf'))) RETURNING id;`
 	ID := -1
@@ -47,3 +40,35 @@ func (s *PostgresStore) IsUserExisting(id int) (bool, error) {
 
 	return exists, err
 }
+
+func (s *PostgresStore) GetUserIDByEmail(email string) (int, error) {
+	query := `SELECT id FROM users WHERE email = $1;`
+
+	ID := -1
+	err := s.db.QueryRow(query, email).Scan(&ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return -1, ErrUserNotFound
+	}
+
+	return ID, err
+}
+
+// CheckPassword reports whether password is the user's current password.
+func (s *PostgresStore) CheckPassword(userId int, password string) (bool, error) {
+	query := `SELECT password = crypt($2, password) FROM users WHERE id = $1;`
+
+	matches := false
+	err := s.db.QueryRow(query, userId, password).Scan(&matches)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, ErrUserNotFound
+	}
+
+	return matches, err
+}
+
+func (s *PostgresStore) SetPassword(userId int, password string) error {
+	query := `UPDATE users SET password = crypt($2, gen_salt('bf')) WHERE id = $1;`
+
+	_, err := s.db.Exec(query, userId, password)
+	return err
+}
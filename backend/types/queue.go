@@ -0,0 +1,153 @@
+package types
+
+import "sync"
+
+// Frame is a single outgoing WebSocket message waiting in a client's queue.
+type Frame struct {
+	Data []byte
+	// Frames with the same non-empty Key carry successive states of the same
+	// thing (e.g. a member's read position), so only the latest is worth sending.
+	Key string
+	// Event log cursor of the frame, 0 for frames that are not logged
+	Cursor int64
+}
+
+// OverflowPolicy decides what SendQueue.Push does when the queue is full.
+type OverflowPolicy string
+
+const (
+	// OverflowDropOldest discards the oldest queued frame to make room.
+	OverflowDropOldest OverflowPolicy = "drop_oldest"
+	// OverflowDisconnect reports the overflow so the client can be dropped.
+	OverflowDisconnect OverflowPolicy = "disconnect"
+	// OverflowCoalesce replaces queued frames that share the new frame's Key
+	// and, when full, discards the oldest keyed frame. Only when no keyed frame
+	// is left to discard is the overflow reported.
+	OverflowCoalesce OverflowPolicy = "coalesce"
+)
+
+// PushResult is the outcome of SendQueue.Push.
+type PushResult int
+
+const (
+	PushQueued PushResult = iota
+	PushCoalesced
+	PushDropped
+	PushOverflow
+	PushClosed
+)
+
+// SendQueue is a bounded, thread-safe FIFO of frames for one client.
+type SendQueue struct {
+	mu     sync.Mutex
+	frames []Frame
+	limit  int
+	closed bool
+	ready  chan struct{}
+}
+
+func NewSendQueue(limit int) *SendQueue {
+	return &SendQueue{
+		limit: limit,
+		ready: make(chan struct{}, 1),
+	}
+}
+
+func (q *SendQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Push adds frame to the queue, applying policy if the queue is full.
+func (q *SendQueue) Push(frame Frame, policy OverflowPolicy) PushResult {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return PushClosed
+	}
+
+	if policy == OverflowCoalesce && frame.Key != "" {
+		for i := range q.frames {
+			if q.frames[i].Key == frame.Key {
+				q.frames[i] = frame
+				return PushCoalesced
+			}
+		}
+	}
+
+	result := PushQueued
+	if len(q.frames) >= q.limit {
+		evict := -1
+		switch policy {
+		case OverflowDropOldest:
+			evict = 0
+		case OverflowCoalesce:
+			for i := range q.frames {
+				if q.frames[i].Key != "" {
+					evict = i
+					break
+				}
+			}
+		}
+
+		if evict == -1 {
+			return PushOverflow
+		}
+
+		q.frames = append(q.frames[:evict], q.frames[evict+1:]...)
+		result = PushDropped
+	}
+
+	q.frames = append(q.frames, frame)
+	q.signal()
+
+	return result
+}
+
+// Pop removes and returns the oldest frame.
+func (q *SendQueue) Pop() (Frame, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.frames) == 0 {
+		return Frame{}, false
+	}
+
+	frame := q.frames[0]
+	q.frames[0] = Frame{}
+	q.frames = q.frames[1:]
+
+	return frame, true
+}
+
+// Ready is signalled whenever frames are pushed or the queue is closed.
+func (q *SendQueue) Ready() <-chan struct{} {
+	return q.ready
+}
+
+// Close discards queued frames and rejects further pushes.
+func (q *SendQueue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	q.frames = nil
+	q.signal()
+}
+
+func (q *SendQueue) Closed() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.closed
+}
+
+func (q *SendQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.frames)
+}
@@ -0,0 +1,122 @@
+package types
+
+import (
+	"slices"
+	"testing"
+)
+
+// frames returns what is left in q, oldest first.
+func frames(q *SendQueue) []string {
+	var data []string
+	for {
+		frame, ok := q.Pop()
+		if !ok {
+			return data
+		}
+		data = append(data, string(frame.Data))
+	}
+}
+
+func TestSendQueuePush(t *testing.T) {
+	type push struct {
+		data, key string
+		want      PushResult
+	}
+
+	tests := []struct {
+		name   string
+		policy OverflowPolicy
+		pushes []push
+		want   []string
+	}{
+		{
+			name:   "drop_oldest under limit",
+			policy: OverflowDropOldest,
+			pushes: []push{{"a", "", PushQueued}, {"b", "", PushQueued}},
+			want:   []string{"a", "b"},
+		},
+		{
+			name:   "drop_oldest when full",
+			policy: OverflowDropOldest,
+			pushes: []push{{"a", "", PushQueued}, {"b", "", PushQueued}, {"c", "", PushQueued}, {"d", "", PushDropped}},
+			want:   []string{"b", "c", "d"},
+		},
+		{
+			name:   "drop_oldest ignores keys",
+			policy: OverflowDropOldest,
+			pushes: []push{{"a", "k", PushQueued}, {"b", "k", PushQueued}},
+			want:   []string{"a", "b"},
+		},
+		{
+			name:   "disconnect when full",
+			policy: OverflowDisconnect,
+			pushes: []push{{"a", "", PushQueued}, {"b", "", PushQueued}, {"c", "", PushQueued}, {"d", "", PushOverflow}},
+			want:   []string{"a", "b", "c"},
+		},
+		{
+			name:   "coalesce replaces same key in place",
+			policy: OverflowCoalesce,
+			pushes: []push{{"a1", "a", PushQueued}, {"b", "", PushQueued}, {"a2", "a", PushCoalesced}},
+			want:   []string{"a2", "b"},
+		},
+		{
+			name:   "coalesce keeps frames without key",
+			policy: OverflowCoalesce,
+			pushes: []push{{"a", "", PushQueued}, {"b", "", PushQueued}},
+			want:   []string{"a", "b"},
+		},
+		{
+			name:   "coalesce drops oldest keyed frame when full",
+			policy: OverflowCoalesce,
+			pushes: []push{{"a", "", PushQueued}, {"k1", "k", PushQueued}, {"b", "", PushQueued}, {"c", "", PushDropped}},
+			want:   []string{"a", "b", "c"},
+		},
+		{
+			name:   "coalesce when full of same key",
+			policy: OverflowCoalesce,
+			pushes: []push{{"a", "", PushQueued}, {"b", "", PushQueued}, {"k1", "k", PushQueued}, {"k2", "k", PushCoalesced}},
+			want:   []string{"a", "b", "k2"},
+		},
+		{
+			name:   "coalesce overflows without keyed frames",
+			policy: OverflowCoalesce,
+			pushes: []push{{"a", "", PushQueued}, {"b", "", PushQueued}, {"c", "", PushQueued}, {"k", "k", PushOverflow}},
+			want:   []string{"a", "b", "c"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := NewSendQueue(3)
+
+			for _, p := range tt.pushes {
+				if got := q.Push(Frame{Data: []byte(p.data), Key: p.key}, tt.policy); got != p.want {
+					t.Fatalf("Push(%q) = %v, want %v", p.data, got, p.want)
+				}
+			}
+
+			if got := frames(q); !slices.Equal(got, tt.want) {
+				t.Errorf("queue = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendQueueClose(t *testing.T) {
+	q := NewSendQueue(3)
+	q.Push(Frame{Data: []byte("a")}, OverflowDropOldest)
+	q.Close()
+
+	if !q.Closed() || q.Len() != 0 {
+		t.Fatalf("Closed() = %v, Len() = %d, want true, 0", q.Closed(), q.Len())
+	}
+	if got := q.Push(Frame{Data: []byte("b")}, OverflowDropOldest); got != PushClosed {
+		t.Errorf("Push after Close = %v, want %v", got, PushClosed)
+	}
+
+	select {
+	case <-q.Ready():
+	default:
+		t.Error("Close did not signal Ready")
+	}
+}
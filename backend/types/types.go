@@ -6,7 +6,7 @@ type User struct {
 	ID        int       `json:"id"`
 	Email     string    `json:"email"`
 	Password  string    `json:"password"`
-	CreatedAt time.Time `json	:"created_at"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
 type Credentials struct {
@@ -20,6 +20,32 @@ type SuccessAuthResponse struct {
 	Action    string `json:"action"`
 }
 
+// Session is a signed in device. Current marks the session of the request.
+type Session struct {
+	ID         int       `json:"id"`
+	UserID     int       `json:"-"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastActive time.Time `json:"last_active"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
 type ProfileRequest struct {
 	Name    string `json:"name"`
 	Surname string `json:"surname"`
@@ -44,10 +70,142 @@ type ChatShortInfo struct {
 	CreatedAt         time.Time `json:"created_at"`
 	IsGroup           bool      `json:"is_group"`
 	Name              string    `json:"name"`
+	AvatarID          int       `json:"avatar_id,omitempty"`
+	MemberCount       int       `json:"member_count"`
+	UnreadCount       int       `json:"unread_count"`
+	LastMessageId     int       `json:"last_message_id"`
 	LastSenderId      int       `json:"last_sender_id"`
 	Message           string    `json:"message"`
-	LastActivity      string    `json:"last_activity"`
+	MessageIsSystem   bool      `json:"message_is_system,omitempty"`
+	LastActivity      time.Time `json:"last_activity"`
 	LastSenderName    string    `json:"last_sender_name"`
 	LastSenderSurname string    `json:"last_sender_surname"`
 	LastSenderPfp     string    `json:"last_sender_pfp"`
 }
+
+type Chat struct {
+	ID        int       `json:"chat_id"`
+	CreatedAt time.Time `json:"created_at"`
+	IsGroup   bool      `json:"is_group"`
+	Name      string    `json:"name"`
+	// Attachment id of the group picture, 0 if there is none
+	AvatarID int `json:"avatar_id,omitempty"`
+}
+
+type DirectChatRequest struct {
+	UserID int `json:"user_id"`
+}
+
+type ChatListPage struct {
+	Chats      []ChatShortInfo `json:"chats"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+// ProfileSnippet is the part of a profile shown next to a message.
+type ProfileSnippet struct {
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	Surname string `json:"surname"`
+	Pfp     string `json:"pfp"`
+}
+
+// Member roles in a chat. Group creators are owners.
+const (
+	ChatRoleOwner  = "owner"
+	ChatRoleAdmin  = "admin"
+	ChatRoleMember = "member"
+)
+
+type ChatMessage struct {
+	MessageID int    `json:"message_id"`
+	ChatID    int    `json:"chat_id"`
+	SenderID  int    `json:"sender_id"`
+	Content   string `json:"content"`
+	// System messages have a SystemMessage as content
+	IsSystem bool       `json:"is_system,omitempty"`
+	SentAt   time.Time  `json:"sent_at"`
+	EditedAt *time.Time `json:"edited_at,omitempty"`
+	// Deleted messages are tombstones with empty content
+	DeletedAt   *time.Time        `json:"deleted_at,omitempty"`
+	Sender      ProfileSnippet    `json:"sender"`
+	ReplyTo     *MessagePreview   `json:"reply_to,omitempty"`
+	Reactions   []ReactionSummary `json:"reactions,omitempty"`
+	Attachments []Attachment      `json:"attachments,omitempty"`
+	// Set when the requesting user blocked the sender
+	Blocked bool `json:"blocked,omitempty"`
+}
+
+// Attachment is an uploaded file, downloadable at /attachments/{id}.
+type Attachment struct {
+	ID           int       `json:"id"`
+	UploaderID   int       `json:"uploader_id"`
+	MessageID    int       `json:"message_id,omitempty"`
+	ChatID       int       `json:"chat_id,omitempty"`
+	Path         string    `json:"-"`
+	OriginalName string    `json:"name"`
+	MimeType     string    `json:"mime_type"`
+	Size         int64     `json:"size"`
+	Width        int       `json:"width,omitempty"`
+	Height       int       `json:"height,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// ReactionSummary aggregates one reaction on a message.
+type ReactionSummary struct {
+	Reaction string `json:"reaction"`
+	Count    int    `json:"count"`
+	// Users who reacted, in the order they did
+	UserIDs []int `json:"user_ids"`
+}
+
+// maxPreviewLength is the number of characters kept in message previews.
+const maxPreviewLength = 100
+
+// MessagePreview is a short quote of a message, e.g. the one being replied to.
+type MessagePreview struct {
+	MessageID int    `json:"message_id"`
+	SenderID  int    `json:"sender_id"`
+	Content   string `json:"content"`
+	Deleted   bool   `json:"deleted,omitempty"`
+}
+
+// Preview returns a preview of the message with its content shortened.
+func (m ChatMessage) Preview() *MessagePreview {
+	content := []rune(m.Content)
+	if len(content) > maxPreviewLength {
+		content = append(content[:maxPreviewLength-1], '…')
+	}
+
+	return &MessagePreview{
+		MessageID: m.MessageID,
+		SenderID:  m.SenderID,
+		Content:   string(content),
+		Deleted:   m.DeletedAt != nil,
+	}
+}
+
+// SystemMessage is the content of a system message, describing a change made
+// to a group chat by ActorID.
+type SystemMessage struct {
+	Action  string `json:"action"`
+	ActorID int    `json:"actor_id"`
+	UserIDs []int  `json:"user_ids,omitempty"`
+	Name    string `json:"name,omitempty"`
+	Role    string `json:"role,omitempty"`
+}
+
+// MessageEdit is a previous version of an edited message.
+type MessageEdit struct {
+	Content  string    `json:"content"`
+	EditedAt time.Time `json:"edited_at"`
+}
+
+type ChatMessagesPage struct {
+	Messages []ChatMessage `json:"messages"`
+	HasMore  bool          `json:"has_more"`
+}
+
+type ReadCursor struct {
+	UserID            int `json:"user_id"`
+	LastReadMessageID int `json:"last_read_message_id"`
+}
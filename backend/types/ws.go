@@ -8,32 +8,64 @@ import (
 )
 
 type Client struct {
-	Conn    *websocket.Conn
-	UserID  int
-	ChatIDs map[int]bool
-	Send    chan []byte
+	Conn      *websocket.Conn
+	UserID    int
+	SessionID int
+	ChatIDs   map[int]bool
+	Send      *SendQueue
+
+	// Set by the hub before Send is closed, sent to the client in the close frame
+	CloseCode   int
+	CloseReason string
 }
 
 type IncomingBase struct {
-	Type string `json:"type"`
+	Type      string `json:"type"`
+	RequestID string `json:"request_id,omitempty"`
 }
 
+// EventFanOutGap is delivered by a fan-out that may have missed events of
+// other instances. It is handled by the instance itself and never sent to clients.
+const EventFanOutGap = "fanOutGap"
+
 type OutgoingBase struct {
 	Type       string          `json:"type"`
 	Data       json.RawMessage `json:"Data"`
 	VerifyType string          `json:"verify_type"`
 	VerifyIDs  []int           `json:"verify_id"`
+	ExcludeIDs []int           `json:"exclude_ids,omitempty"` // Users left out of the recipients
+	Cursor     int64           `json:"cursor,omitempty"`
 }
 
 type Final struct {
-	Type string          `json:"type"`
-	Data json.RawMessage `json:"data"`
+	Type   string          `json:"type"`
+	Data   json.RawMessage `json:"data"`
+	Cursor int64           `json:"cursor,omitempty"`
+}
+
+// AckData is sent only to the client whose request succeeded.
+type AckData struct {
+	RequestID string          `json:"request_id"`
+	For       string          `json:"for"`
+	Result    json.RawMessage `json:"result,omitempty"`
+}
+
+// ErrorData is sent only to the client whose request failed.
+type ErrorData struct {
+	RequestID string `json:"request_id,omitempty"`
+	For       string `json:"for"`
+	Code      string `json:"code"`
+	Message   string `json:"message"`
 }
 
 type NewMessage struct {
 	Type    string `json:"type"`
 	Content string `json:"content"`
 	ChatID  int    `json:"chat_id"`
+	// Optional message of the same chat this one replies to
+	ReplyToMessageID int `json:"reply_to_message_id,omitempty"`
+	// Uploaded, not yet sent attachments of the sender
+	AttachmentIDs []int `json:"attachment_ids,omitempty"`
 }
 
 type IncomingFR struct {
@@ -66,12 +98,69 @@ type RemoveFriendData struct {
 	FriendID int `json:"friend_id"`
 }
 
+type Block struct {
+	Type   string `json:"type"`
+	UserID int    `json:"user_id"`
+}
+
+type BlockData struct {
+	UserID int `json:"user_id"`
+}
+
 type NewMessageData struct {
-	Content   string    `json:"content"`
-	ChatID    int       `json:"chat_id"`
-	SenderID  int       `json:"sender_id"`
-	SentAt    time.Time `json:"sent_at"`
+	Content     string          `json:"content"`
+	IsSystem    bool            `json:"is_system,omitempty"`
+	ChatID      int             `json:"chat_id"`
+	SenderID    int             `json:"sender_id"`
+	SentAt      time.Time       `json:"sent_at"`
+	MessageID   int             `json:"message_id"`
+	ReplyTo     *MessagePreview `json:"reply_to,omitempty"`
+	Attachments []Attachment    `json:"attachments,omitempty"`
+	Blocked     bool            `json:"blocked,omitempty"` // Set only in the copy sent to members who blocked the sender
+}
+
+type EditMessage struct {
+	Type      string `json:"type"`
+	MessageID int    `json:"message_id"`
+	Content   string `json:"content"`
+}
+
+type MessageEditedData struct {
 	MessageID int       `json:"message_id"`
+	ChatID    int       `json:"chat_id"`
+	Content   string    `json:"content"`
+	EditedAt  time.Time `json:"edited_at"`
+	EditedBy  int       `json:"edited_by"`
+}
+
+type DeleteMessage struct {
+	Type      string `json:"type"`
+	MessageID int    `json:"message_id"`
+	// Without ForEveryone the message is only hidden for the requesting user
+	ForEveryone bool `json:"for_everyone"`
+}
+
+type MessageDeletedData struct {
+	MessageID   int  `json:"message_id"`
+	ChatID      int  `json:"chat_id"`
+	ForEveryone bool `json:"for_everyone"`
+	DeletedBy   int  `json:"deleted_by"`
+}
+
+// Reaction is the body of both addReaction and removeReaction.
+type Reaction struct {
+	Type      string `json:"type"`
+	MessageID int    `json:"message_id"`
+	Reaction  string `json:"reaction"`
+}
+
+type ReactionChangedData struct {
+	MessageID int               `json:"message_id"`
+	ChatID    int               `json:"chat_id"`
+	UserID    int               `json:"user_id"`
+	Reaction  string            `json:"reaction"`
+	Added     bool              `json:"added"`
+	Reactions []ReactionSummary `json:"reactions"`
 }
 
 type NewChat struct {
@@ -84,5 +173,85 @@ type NewChatData struct {
 	ChatID   int       `json:"chat_id"`
 	Members  []int     `json:"members"`
 	ChatName string    `json:"chat_name"`
+	IsGroup  bool      `json:"is_group"`
 	SentAt   time.Time `json:"sent_at"`
 }
+
+type OpenDirectChat struct {
+	Type   string `json:"type"`
+	UserID int    `json:"user_id"`
+}
+
+type AddMembers struct {
+	Type    string `json:"type"`
+	ChatID  int    `json:"chat_id"`
+	UserIDs []int  `json:"user_ids"`
+}
+
+type RemoveMember struct {
+	Type   string `json:"type"`
+	ChatID int    `json:"chat_id"`
+	UserID int    `json:"user_id"`
+}
+
+type LeaveChat struct {
+	Type   string `json:"type"`
+	ChatID int    `json:"chat_id"`
+}
+
+type RenameChat struct {
+	Type   string `json:"type"`
+	ChatID int    `json:"chat_id"`
+	Name   string `json:"name"`
+}
+
+type PromoteMember struct {
+	Type   string `json:"type"`
+	ChatID int    `json:"chat_id"`
+	UserID int    `json:"user_id"`
+	Role   string `json:"role"`
+}
+
+type SetChatAvatar struct {
+	Type   string `json:"type"`
+	ChatID int    `json:"chat_id"`
+	// Unsent image attachment of the client, 0 removes the picture
+	AttachmentID int `json:"attachment_id"`
+}
+
+type ChatMembershipChangedData struct {
+	ChatID  int    `json:"chat_id"`
+	Added   []int  `json:"added"`
+	Removed []int  `json:"removed"`
+	Reason  string `json:"reason"`
+}
+
+// Event is a broadcast event stored in the per-user event log.
+type Event struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// ResyncData tells a reconnecting client that its cursor can not be replayed
+// and it must reload its state, then continue from Cursor.
+type ResyncData struct {
+	Cursor int64 `json:"cursor"`
+}
+
+type MarkRead struct {
+	Type      string `json:"type"`
+	ChatID    int    `json:"chat_id"`
+	MessageID int    `json:"message_id"`
+}
+
+type ReadReceiptData struct {
+	ChatID    int `json:"chat_id"`
+	UserID    int `json:"user_id"`
+	MessageID int `json:"message_id"`
+}
+
+type SessionsRevokedData struct {
+	SessionIDs []int `json:"session_ids"`
+}
@@ -4,12 +4,14 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
-	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"time"
 )
 
@@ -32,10 +34,23 @@ func GenerateFileName() (string, error) {
 	return hashedName, nil
 }
 
-func ValidateFileType(file multipart.File) (string, error) {
+// fileExtensions maps MIME types to the extension of stored files.
+var fileExtensions = map[string]string{
+	"image/png":       ".png",
+	"image/jpeg":      ".jpg",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+	"video/mp4":       ".mp4",
+	"audio/mpeg":      ".mp3",
+	"application/pdf": ".pdf",
+	"text/plain":      ".txt",
+}
+
+// DetectFileType sniffs the MIME type of a file from its content, without parameters.
+func DetectFileType(file multipart.File) (string, error) {
 	// Read a small portion of the file to detect its MIME type
 	buffer := make([]byte, 512) // 512 bytes are enough to sniff the content type
-	_, err := file.Read(buffer)
+	n, err := file.Read(buffer)
 	if err != nil {
 		return "", err
 	}
@@ -45,56 +60,78 @@ func ValidateFileType(file multipart.File) (string, error) {
 		return "", err
 	}
 
-	// Detect the content type (MIME type)
-	mimeType := http.DetectContentType(buffer)
-
-	// Check if it's a valid PNG or JPG/JPEG
-	if mimeType == "image/png" {
-		return "png", nil
-	}
-	if mimeType == "image/jpeg" {
-		return "jpg", nil
-	}
+	mimeType, _, _ := strings.Cut(http.DetectContentType(buffer[:n]), ";")
+	return mimeType, nil
+}
 
-	return "", nil
+// UploadedFile describes a file saved by SaveUpload.
+type UploadedFile struct {
+	Path         string
+	OriginalName string
+	MimeType     string
+	Size         int64
 }
 
-func UploadProfilePicture(r *http.Request) (string, error) {
+// SaveUpload stores the multipart file of the given form field in dir under a
+// random name. The file must be at most maxSize bytes and its sniffed MIME type
+// one of allowedTypes.
+func SaveUpload(r *http.Request, field, dir string, maxSize int64, allowedTypes []string) (UploadedFile, error) {
 	// Parse file
-	r.ParseMultipartForm(10 << 20) // Ograniczenie do 10MB
-	file, _, err := r.FormFile("profile_picture")
+	file, header, err := r.FormFile(field)
 	if err != nil {
-		return "", err
+		return UploadedFile{}, err
 	}
 	defer file.Close()
 
-	// Validate if it's a PNG or JPG file
-	imgType, err := ValidateFileType(file)
+	if header.Size > maxSize {
+		return UploadedFile{}, fmt.Errorf("file exceeds %d bytes", maxSize)
+	}
+
+	mimeType, err := DetectFileType(file)
 	if err != nil {
-		return "", err
+		return UploadedFile{}, err
 	}
 
-	if imgType != "png" && imgType != "jpg" {
-		return "", errors.New("only pnh and jpg files are allowed")
+	if !slices.Contains(allowedTypes, mimeType) {
+		return UploadedFile{}, fmt.Errorf("files of type %s are not allowed", mimeType)
 	}
 
-	// Save image to uploads folder
+	// Save file to the upload folder
 	fileName, err := GenerateFileName()
 	if err != nil {
-		return "", err
+		return UploadedFile{}, err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return UploadedFile{}, err
 	}
 
-	filePath := fmt.Sprintf("./uploads/pfp/%s", fileName+"."+imgType)
+	filePath := fmt.Sprintf("%s/%s", dir, fileName+fileExtensions[mimeType])
 	f, err := os.Create(filePath)
 	if err != nil {
-		return "", err
+		return UploadedFile{}, err
 	}
 	defer f.Close()
 
-	_, err = io.Copy(f, file)
+	size, err := io.Copy(f, file)
+	if err != nil {
+		os.Remove(filePath)
+		return UploadedFile{}, err
+	}
+
+	return UploadedFile{
+		Path:         filePath,
+		OriginalName: filepath.Base(header.Filename),
+		MimeType:     mimeType,
+		Size:         size,
+	}, nil
+}
+
+func UploadProfilePicture(r *http.Request, dir string, maxSize int64) (string, error) {
+	upload, err := SaveUpload(r, "profile_picture", dir, maxSize, []string{"image/png", "image/jpeg"})
 	if err != nil {
 		return "", err
 	}
 
-	return filePath, nil
+	return upload.Path, nil
 }
@@ -2,40 +2,98 @@ package ws
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"slices"
 	"time"
 
+	"github.com/carson2222/social-app/storage"
 	"github.com/carson2222/social-app/types"
 )
 
-// TODO: Save message in database
-func (ws *WebSocketServer) handleMessage(client *types.Client, rawMessage []byte) {
+// maxAttachments is the most attachments a single message can carry.
+const maxAttachments = 10
+
+func (ws *WebSocketServer) handleMessage(client *types.Client, rawMessage []byte) (any, error) {
 	var message types.NewMessage
 
-	err := json.Unmarshal(rawMessage, &message)
-	if err != nil {
-		log.Printf("Error unmarshaling message: %v\n", err)
-		return
+	if err := json.Unmarshal(rawMessage, &message); err != nil {
+		return nil, newError(CodeValidationFailed, "invalid message: %v", err)
 	}
 
 	// Validate message content
-	if message.Content == "" {
-		log.Print("Empty message")
-		return
+	if message.Content == "" && len(message.AttachmentIDs) == 0 {
+		return nil, newError(CodeValidationFailed, "message content is empty")
+	}
+
+	if len(message.AttachmentIDs) > maxAttachments {
+		return nil, newError(CodeValidationFailed, "a message can have at most %d attachments", maxAttachments)
 	}
 
 	// Check if user is in chat
 	if err := ws.storage.IsUserInChat(client.UserID, message.ChatID); err != nil {
-		log.Printf("Error checking if user is in chat: %v\n", err)
-		return
+		if errors.Is(err, storage.ErrNotInChat) {
+			return nil, newError(CodeNotInChat, "you are not a member of chat %d", message.ChatID)
+		}
+		return nil, fmt.Errorf("failed to check if user is in chat: %w", err)
+	}
+
+	// Direct messages are refused while either user blocks the other, group
+	// members who blocked the sender get it flagged as blocked
+	blockers, err := ws.messageBlockers(client.UserID, message.ChatID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check if the quoted message is in the same chat
+	var replyTo *types.MessagePreview
+	if message.ReplyToMessageID != 0 {
+		quoted, err := ws.storage.GetMessage(message.ReplyToMessageID)
+		if errors.Is(err, storage.ErrMessageNotFound) || (err == nil && quoted.ChatID != message.ChatID) {
+			return nil, newError(CodeMessageNotFound, "message %d is not in chat %d", message.ReplyToMessageID, message.ChatID)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get quoted message: %w", err)
+		}
+
+		if quoted.DeletedAt != nil {
+			return nil, newError(CodeMessageNotFound, "message %d was deleted", quoted.MessageID)
+		}
+		replyTo = quoted.Preview()
+	}
+
+	// Check if the attachments are the sender's and not sent yet
+	attachments := make([]types.Attachment, 0, len(message.AttachmentIDs))
+	for i, attachmentId := range message.AttachmentIDs {
+		if slices.Contains(message.AttachmentIDs[:i], attachmentId) {
+			return nil, newError(CodeValidationFailed, "attachment %d is listed twice", attachmentId)
+		}
+
+		attachment, err := ws.storage.GetAttachment(attachmentId)
+		if errors.Is(err, storage.ErrAttachmentNotFound) || (err == nil && (attachment.UploaderID != client.UserID || attachment.MessageID != 0)) {
+			return nil, newError(CodeAttachmentNotFound, "attachment %d does not exist or was already sent", attachmentId)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get attachment: %w", err)
+		}
+
+		attachment.ChatID = message.ChatID
+		attachments = append(attachments, attachment)
 	}
 
 	now := time.Now()
-	var messageID int
 	// Insert message into database
-	if messageID, err = ws.storage.NewMessage(message.ChatID, client.UserID, message.Content, now); err != nil || messageID == -1 {
-		log.Printf("Error inserting message into database: %v\n", err)
-		return
+	messageID, err := ws.storage.NewMessage(message.ChatID, client.UserID, message.Content, message.ReplyToMessageID, message.AttachmentIDs, now)
+	if errors.Is(err, storage.ErrAttachmentNotFound) {
+		return nil, newError(CodeAttachmentNotFound, "an attachment was already sent")
+	}
+	if err != nil || messageID == -1 {
+		return nil, fmt.Errorf("failed to insert message into database: %w", err)
+	}
+
+	for i := range attachments {
+		attachments[i].MessageID = messageID
 	}
 
 	// Format Data
@@ -45,79 +103,78 @@ func (ws *WebSocketServer) handleMessage(client *types.Client, rawMessage []byte
 		SenderID:  client.UserID,
 		SentAt:    now,
 		MessageID: messageID,
+		ReplyTo:   replyTo,
 	}
-
-	dataRaw, err := json.Marshal(data)
-	if err != nil {
-		log.Printf("Error marshaling message: %v\n", err)
-		return
+	if len(attachments) > 0 {
+		data.Attachments = attachments
 	}
 
-	// Format the message that will be served to other users
-	outgoingMsg := types.OutgoingBase{
-		Type:       message.Type,
-		Data:       json.RawMessage(dataRaw),
-		VerifyIDs:  []int{message.ChatID},
-		VerifyType: "chatID",
+	// Broadcast the message to the chat, the blockers get their own copy so
+	// nobody else learns who blocked the sender
+	if err := ws.publishEventExcept("newMessage", "chatID", []int{message.ChatID}, blockers, data); err != nil {
+		return nil, err
 	}
 
-	// Marshal the outgoing message
-	marshaledMsg, err := json.Marshal(outgoingMsg)
-	if err != nil {
-		log.Printf("Error marshaling message: %v\n", err)
-		return
+	if len(blockers) > 0 {
+		blockedData := data
+		blockedData.Blocked = true
+		if err := ws.publishEvent("newMessage", "userID", blockers, blockedData); err != nil {
+			return nil, err
+		}
 	}
 
-	// Broadcast the message
-	ws.broadcast <- marshaledMsg
+	return data, nil
 }
 
-func (ws *WebSocketServer) handleNewChat(client *types.Client, rawMessage []byte) {
+func (ws *WebSocketServer) handleNewChat(client *types.Client, rawMessage []byte) (any, error) {
 	var message types.NewChat
 
-	err := json.Unmarshal(rawMessage, &message)
-	if err != nil {
-		log.Printf("Error unmarshaling message: %v\n", err)
-		return
+	if err := json.Unmarshal(rawMessage, &message); err != nil {
+		return nil, newError(CodeValidationFailed, "invalid message: %v", err)
 	}
 
-	// Validate members
-	areMembersValid := true
+	// Members are the other users, the creator is always added
+	members := []int{}
 	for _, member := range message.Members {
-		var exists bool
-		if exists, err = ws.storage.IsUserExisting(member); err != nil {
-			log.Printf("Error checking if user exists: %v\n", err)
-			areMembersValid = false
+		if member != client.UserID && !slices.Contains(members, member) {
+			members = append(members, member)
 		}
+	}
 
-		if !exists {
-			log.Printf("User %d does not exist\n", member)
-			areMembersValid = false
-		}
+	if len(members) == 0 {
+		return nil, newError(CodeValidationFailed, "invalid number of members")
 	}
 
-	if !areMembersValid || len(message.Members) == 2 {
-		log.Print("Invalid members")
-		return
+	// A chat with a single other user is their direct chat
+	if len(members) == 1 {
+		return ws.OpenDirectChat(client.UserID, members[0])
 	}
 
-	// TODO: Check if all users are friends (LATER)
+	// Validate members
+	for _, member := range members {
+		exists, err := ws.storage.IsUserExisting(member)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check if user exists: %w", err)
+		}
 
-	// If it's a private chat, check if it's already existing
-	if len(message.Members) == 1 {
-		if exists, err := ws.storage.IsPrivateChatExisting(message.Members[0], client.UserID); err != nil || !exists {
-			log.Printf("Error checking if private chat exists: %v\n", err)
-			return
+		if !exists {
+			return nil, newError(CodeUserNotFound, "user %d does not exist", member)
 		}
 	}
 
+	// TODO: Check if all users are friends (LATER)
+
 	// Create new chat and broadcast message
-	membersWithCreator := append([]int{client.UserID}, message.Members...)
+	membersWithCreator := append([]int{client.UserID}, members...)
 
 	chatId, err := ws.storage.InitNewChat(message.ChatName, membersWithCreator)
 	if err != nil {
-		log.Printf("Error creating new chat: %v\n", err)
-		return
+		return nil, fmt.Errorf("failed to create new chat: %w", err)
+	}
+
+	// Subscribe the members' connected devices to the new chat
+	if err := ws.publishMembershipChange(chatId, membersWithCreator, nil, membershipChatCreated); err != nil {
+		log.Printf("Error publishing membership change: %v\n", err)
 	}
 
 	// Create a data json
@@ -125,29 +182,283 @@ func (ws *WebSocketServer) handleNewChat(client *types.Client, rawMessage []byte
 		ChatID:   chatId,
 		ChatName: message.ChatName,
 		Members:  membersWithCreator,
+		IsGroup:  true,
 		SentAt:   time.Now(),
 	}
-	dataRaw, err := json.Marshal(data)
+
+	if err := ws.publishEvent("newChat", "userID", membersWithCreator, data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (ws *WebSocketServer) handleMarkRead(client *types.Client, rawMessage []byte) (any, error) {
+	var message types.MarkRead
+
+	if err := json.Unmarshal(rawMessage, &message); err != nil {
+		return nil, newError(CodeValidationFailed, "invalid message: %v", err)
+	}
+
+	if message.MessageID < 1 {
+		return nil, newError(CodeValidationFailed, "invalid message id")
+	}
+
+	// Check if user is in chat
+	if err := ws.storage.IsUserInChat(client.UserID, message.ChatID); err != nil {
+		if errors.Is(err, storage.ErrNotInChat) {
+			return nil, newError(CodeNotInChat, "you are not a member of chat %d", message.ChatID)
+		}
+		return nil, fmt.Errorf("failed to check if user is in chat: %w", err)
+	}
+
+	lastRead, err := ws.storage.MarkRead(client.UserID, message.ChatID, message.MessageID)
+	if errors.Is(err, storage.ErrMessageNotFound) {
+		return nil, newError(CodeMessageNotFound, "message %d is not in chat %d", message.MessageID, message.ChatID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to mark messages as read: %w", err)
+	}
+
+	data := types.ReadReceiptData{
+		ChatID:    message.ChatID,
+		UserID:    client.UserID,
+		MessageID: lastRead,
+	}
+
+	// The cursor only moves forward, older messages were already reported as read
+	if lastRead != message.MessageID {
+		return data, nil
+	}
+
+	if err := ws.publishEvent("readReceipt", "chatID", []int{message.ChatID}, data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// chatMessage loads a message the client can see, i.e. one in a chat they are
+// a member of, together with the client's role in that chat.
+func (ws *WebSocketServer) chatMessage(client *types.Client, messageId int) (types.ChatMessage, string, error) {
+	message, err := ws.storage.GetMessage(messageId)
+	if errors.Is(err, storage.ErrMessageNotFound) {
+		return message, "", newError(CodeMessageNotFound, "message %d does not exist", messageId)
+	}
+	if err != nil {
+		return message, "", fmt.Errorf("failed to get message: %w", err)
+	}
+
+	role, err := ws.storage.GetChatRole(client.UserID, message.ChatID)
+	if errors.Is(err, storage.ErrNotInChat) {
+		// Do not reveal messages of other chats
+		return message, "", newError(CodeMessageNotFound, "message %d does not exist", messageId)
+	}
+	if err != nil {
+		return message, "", fmt.Errorf("failed to get chat role: %w", err)
+	}
+
+	return message, role, nil
+}
+
+func (ws *WebSocketServer) handleEditMessage(client *types.Client, rawMessage []byte) (any, error) {
+	var request types.EditMessage
+
+	if err := json.Unmarshal(rawMessage, &request); err != nil {
+		return nil, newError(CodeValidationFailed, "invalid message: %v", err)
+	}
+
+	if request.Content == "" {
+		return nil, newError(CodeValidationFailed, "message content is empty")
+	}
+
+	message, role, err := ws.chatMessage(client, request.MessageID)
+	if err != nil {
+		return nil, err
+	}
+
+	if message.IsSystem || message.SenderID != client.UserID && !isAdmin(role) {
+		return nil, newError(CodeForbidden, "only the sender or a chat admin can edit a message, system messages stay")
+	}
+
+	if message.DeletedAt != nil {
+		return nil, newError(CodeMessageNotFound, "message %d was deleted", message.MessageID)
+	}
+
+	now := time.Now()
+	err = ws.storage.EditMessage(message.MessageID, request.Content, now)
+	if errors.Is(err, storage.ErrMessageNotFound) {
+		return nil, newError(CodeMessageNotFound, "message %d was deleted", message.MessageID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to edit message: %w", err)
+	}
+
+	data := types.MessageEditedData{
+		MessageID: message.MessageID,
+		ChatID:    message.ChatID,
+		Content:   request.Content,
+		EditedAt:  now,
+		EditedBy:  client.UserID,
+	}
+
+	if err := ws.publishEvent("messageEdited", "chatID", []int{message.ChatID}, data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (ws *WebSocketServer) handleDeleteMessage(client *types.Client, rawMessage []byte) (any, error) {
+	var request types.DeleteMessage
+
+	if err := json.Unmarshal(rawMessage, &request); err != nil {
+		return nil, newError(CodeValidationFailed, "invalid message: %v", err)
+	}
+
+	message, role, err := ws.chatMessage(client, request.MessageID)
 	if err != nil {
-		log.Printf("Error creating content json: %v\n", err)
-		return
+		return nil, err
 	}
 
-	// Format the message that will be served to other users
-	outgoingMsg := types.OutgoingBase{
-		Type:       message.Type,
-		Data:       json.RawMessage(dataRaw),
-		VerifyIDs:  membersWithCreator,
-		VerifyType: "userID",
+	data := types.MessageDeletedData{
+		MessageID:   message.MessageID,
+		ChatID:      message.ChatID,
+		ForEveryone: request.ForEveryone,
+		DeletedBy:   client.UserID,
 	}
 
-	// Marshal the outgoing message
-	marshaledMsg, err := json.Marshal(outgoingMsg)
+	// Deleting for oneself only concerns the user's own devices
+	if !request.ForEveryone {
+		if err := ws.storage.HideMessage(client.UserID, message.MessageID); err != nil {
+			return nil, fmt.Errorf("failed to hide message: %w", err)
+		}
+
+		if err := ws.publishEvent("messageDeleted", "userID", []int{client.UserID}, data); err != nil {
+			return nil, err
+		}
+
+		return data, nil
+	}
+
+	if message.IsSystem || message.SenderID != client.UserID && !isAdmin(role) {
+		return nil, newError(CodeForbidden, "only the sender or a chat admin can delete a message for everyone, system messages stay")
+	}
+
+	err = ws.storage.DeleteMessage(message.MessageID, time.Now())
+	if errors.Is(err, storage.ErrMessageNotFound) {
+		return nil, newError(CodeMessageNotFound, "message %d was already deleted", message.MessageID)
+	}
 	if err != nil {
-		log.Printf("Error marshaling message: %v\n", err)
-		return
+		return nil, fmt.Errorf("failed to delete message: %w", err)
+	}
+
+	if err := ws.publishEvent("messageDeleted", "chatID", []int{message.ChatID}, data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (ws *WebSocketServer) handleOpenDirectChat(client *types.Client, rawMessage []byte) (any, error) {
+	var message types.OpenDirectChat
+
+	if err := json.Unmarshal(rawMessage, &message); err != nil {
+		return nil, newError(CodeValidationFailed, "invalid message: %v", err)
+	}
+
+	return ws.OpenDirectChat(client.UserID, message.UserID)
+}
+
+// OpenDirectChat returns the 1:1 chat of userID and otherID, creating it and
+// announcing it to both users if it does not exist yet. Request failures are
+// returned as *Error.
+func (ws *WebSocketServer) OpenDirectChat(userID, otherID int) (types.NewChatData, error) {
+	if userID == otherID {
+		return types.NewChatData{}, newError(CodeValidationFailed, "user cannot open a chat with themselves")
+	}
+
+	exists, err := ws.storage.IsUserExisting(otherID)
+	if err != nil {
+		return types.NewChatData{}, fmt.Errorf("failed to check if user exists: %w", err)
+	}
+
+	if !exists {
+		return types.NewChatData{}, newError(CodeUserNotFound, "user %d does not exist", otherID)
+	}
+
+	blocked, err := ws.storage.IsBlocked(userID, otherID)
+	if err != nil {
+		return types.NewChatData{}, fmt.Errorf("failed to check if users are blocked: %w", err)
+	}
+
+	if blocked {
+		return types.NewChatData{}, newError(CodeBlocked, "cannot open a chat with this user")
+	}
+
+	chat, created, err := ws.storage.GetOrCreateDirectChat(userID, otherID)
+	if err != nil {
+		return types.NewChatData{}, fmt.Errorf("failed to open direct chat: %w", err)
+	}
+
+	members := []int{userID, otherID}
+	data := types.NewChatData{
+		ChatID:  chat.ID,
+		Members: members,
+		SentAt:  chat.CreatedAt,
+	}
+
+	if !created {
+		return data, nil
+	}
+
+	// Subscribe both users' connected devices to the new chat
+	if err := ws.publishMembershipChange(chat.ID, members, nil, membershipChatCreated); err != nil {
+		log.Printf("Error publishing membership change: %v\n", err)
+	}
+
+	if err := ws.publishEvent("newChat", "userID", members, data); err != nil {
+		return types.NewChatData{}, err
+	}
+
+	return data, nil
+}
+
+// messageBlockers returns the members of a group chat who blocked the sender.
+// In a direct chat it fails with CodeBlocked if either user blocked the other.
+func (ws *WebSocketServer) messageBlockers(senderID, chatID int) ([]int, error) {
+	chat, err := ws.storage.GetChat(chatID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chat: %w", err)
+	}
+
+	if chat.IsGroup {
+		blockers, err := ws.storage.GetChatBlockers(chatID, senderID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get chat blockers: %w", err)
+		}
+		return blockers, nil
+	}
+
+	members, err := ws.storage.GetChatMembers(chatID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chat members: %w", err)
+	}
+
+	for _, member := range members {
+		if member == senderID {
+			continue
+		}
+
+		blocked, err := ws.storage.IsBlocked(senderID, member)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check if users are blocked: %w", err)
+		}
+
+		if blocked {
+			return nil, newError(CodeBlocked, "cannot send messages to this user")
+		}
 	}
 
-	// Broadcast the message
-	ws.broadcast <- marshaledMsg
+	return nil, nil
 }
@@ -0,0 +1,35 @@
+package ws
+
+import "fmt"
+
+// Machine-readable codes sent in error frames.
+const (
+	CodeValidationFailed   = "validation_failed"
+	CodeUnknownType        = "unknown_type"
+	CodeNotInChat          = "not_in_chat"
+	CodeMessageNotFound    = "message_not_found"
+	CodeAttachmentNotFound = "attachment_not_found"
+	CodeForbidden          = "forbidden"
+	CodeUserNotFound       = "user_not_found"
+	CodeAlreadyFriends     = "already_friends"
+	CodeNotFriends         = "not_friends"
+	CodeAlreadyRequested   = "already_requested"
+	CodeNoPendingRequest   = "no_pending_request"
+	CodeBlocked            = "blocked"
+	CodeInternal           = "internal_error"
+)
+
+// Error is a request failure reported back to the client. Any other error
+// returned by a handler is logged and reported as CodeInternal.
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func newError(code, format string, args ...any) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,104 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/carson2222/social-app/storage"
+	"github.com/carson2222/social-app/types"
+	"github.com/gorilla/websocket"
+)
+
+// eventRecipients resolves the users an outgoing event is addressed to.
+func (ws *WebSocketServer) eventRecipients(verifyType string, verifyIDs []int) ([]int, error) {
+	if verifyType != "chatID" {
+		return verifyIDs, nil
+	}
+
+	var userIDs []int
+	for _, chatID := range verifyIDs {
+		members, err := ws.storage.GetChatMembers(chatID)
+		if err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, members...)
+	}
+
+	return userIDs, nil
+}
+
+// logEvent stores the event in the recipients' event logs and sets its cursor.
+// A failure is only logged: live delivery matters more than replay.
+func (ws *WebSocketServer) logEvent(outgoingMsg *types.OutgoingBase) {
+	userIDs, err := ws.eventRecipients(outgoingMsg.VerifyType, outgoingMsg.VerifyIDs)
+	if err != nil {
+		log.Printf("Error resolving recipients of %s: %v", outgoingMsg.Type, err)
+		return
+	}
+	userIDs = slices.DeleteFunc(userIDs, func(userID int) bool {
+		return slices.Contains(outgoingMsg.ExcludeIDs, userID)
+	})
+
+	cursor, err := ws.storage.AppendEvent(outgoingMsg.Type, outgoingMsg.Data, userIDs)
+	if err != nil {
+		log.Printf("Error logging %s event: %v", outgoingMsg.Type, err)
+		return
+	}
+
+	outgoingMsg.Cursor = cursor
+}
+
+// replay writes every event the user missed after since straight to the
+// connection, before the writer goroutine starts. If the cursor is too old or
+// too much was missed, a resync frame is sent instead. It returns the cursor
+// up to which live frames already queued for the client must be skipped.
+func (ws *WebSocketServer) replay(client *types.Client, since int64) (int64, error) {
+	maxReplay := ws.config.EventLog.MaxReplay
+
+	events, err := ws.storage.GetEventsSince(client.UserID, since, maxReplay+1)
+	if errors.Is(err, storage.ErrCursorExpired) || len(events) > maxReplay {
+		return ws.resync(client)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	cursor := since
+	for _, event := range events {
+		if err := ws.writeNow(client, types.Final{Type: event.Type, Data: event.Data, Cursor: event.ID}); err != nil {
+			return 0, err
+		}
+		cursor = event.ID
+	}
+
+	return cursor, nil
+}
+
+func (ws *WebSocketServer) resync(client *types.Client) (int64, error) {
+	cursor, err := ws.storage.GetLatestEventID(client.UserID)
+	if err != nil {
+		return 0, err
+	}
+
+	dataRaw, err := json.Marshal(types.ResyncData{Cursor: cursor})
+	if err != nil {
+		return 0, err
+	}
+
+	return cursor, ws.writeNow(client, types.Final{Type: "resync", Data: dataRaw})
+}
+
+// writeNow writes a frame directly to the connection. It must only be used
+// while no writer goroutine runs for the client.
+func (ws *WebSocketServer) writeNow(client *types.Client, final types.Final) error {
+	finalRaw, err := json.Marshal(final)
+	if err != nil {
+		return err
+	}
+
+	client.Conn.SetWriteDeadline(time.Now().Add(ws.config.WebSocket.WriteWait))
+	return client.Conn.WriteMessage(websocket.TextMessage, finalRaw)
+}
@@ -0,0 +1,29 @@
+package ws
+
+// FanOut carries broadcast events to every server instance. Events are
+// marshaled types.OutgoingBase values; each instance delivers them to its own
+// matching clients.
+type FanOut interface {
+	// Publish sends msg to every instance, this one included.
+	Publish(msg []byte) error
+	// Messages delivers the events published by any instance.
+	Messages() <-chan []byte
+}
+
+// localFanOut is the single instance FanOut.
+type localFanOut struct {
+	messages chan []byte
+}
+
+func NewLocalFanOut() FanOut {
+	return &localFanOut{messages: make(chan []byte)}
+}
+
+func (f *localFanOut) Publish(msg []byte) error {
+	f.messages <- msg
+	return nil
+}
+
+func (f *localFanOut) Messages() <-chan []byte {
+	return f.messages
+}
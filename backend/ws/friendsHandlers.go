@@ -2,49 +2,47 @@ package ws
 
 import (
 	"encoding/json"
-	"log"
+	"fmt"
 
 	"github.com/carson2222/social-app/types"
 )
 
-func (ws *WebSocketServer) handleAcceptFR(client *types.Client, rawMessage []byte) {
+func (ws *WebSocketServer) handleAcceptFR(client *types.Client, rawMessage []byte) (any, error) {
 	message := types.IncomingFR{}
 
 	if err := json.Unmarshal(rawMessage, &message); err != nil {
-		log.Printf("Error unmarshaling message: %v\n", err)
-		return
+		return nil, newError(CodeValidationFailed, "invalid message: %v", err)
 	}
 
 	userId := client.UserID
 	senderID := message.SenderID
 	if userId == senderID {
-		log.Printf("User cannot accept their own friend request")
-		return
+		return nil, newError(CodeValidationFailed, "user cannot accept their own friend request")
 	}
 
 	// Check if users are already friends
 	areFriends, err := ws.storage.AreFriends(userId, senderID)
-	if err != nil || areFriends {
-		log.Printf("Users are already friends " + err.Error())
-		return
+	if err != nil {
+		return nil, fmt.Errorf("failed to check if users are friends: %w", err)
+	}
+
+	if areFriends {
+		return nil, newError(CodeAlreadyFriends, "users are already friends")
 	}
 
 	// Check if user is already requested
 	isRequested, err := ws.storage.IsRequestedFriend(senderID, userId)
 	if err != nil {
-		log.Printf("Failed to check if user is requested friend with the friend:" + err.Error())
-		return
+		return nil, fmt.Errorf("failed to check if user is requested friend with the friend: %w", err)
 	}
 
 	if !isRequested {
-		log.Printf("There is no pending fr from this user")
-		return
+		return nil, newError(CodeNoPendingRequest, "there is no pending friend request from this user")
 	}
 
 	err = ws.storage.AcceptFriendRequest(userId, senderID)
 	if err != nil {
-		log.Printf("Failed to accept friend request:" + err.Error())
-		return
+		return nil, fmt.Errorf("failed to accept friend request: %w", err)
 	}
 
 	// Create data
@@ -52,72 +50,51 @@ func (ws *WebSocketServer) handleAcceptFR(client *types.Client, rawMessage []byt
 		SenderID:   senderID,
 		ReceiverID: userId,
 	}
-	dataRaw, err := json.Marshal(data)
-	if err != nil {
-		log.Printf("Error marshaling message: %v\n", err)
-		return
-	}
 
-	// Create outgoing message
-	outgoingMsg := types.OutgoingBase{
-		Type:       "acceptFR",
-		Data:       dataRaw,
-		VerifyType: "userID",
-		VerifyIDs:  []int{senderID, userId},
-	}
-	marshaledMsg, err := json.Marshal(outgoingMsg)
-	if err != nil {
-		log.Printf("Error marshaling message: %v\n", err)
-		return
+	// Broadcast message
+	if err := ws.publishEvent("acceptFR", "userID", []int{senderID, userId}, data); err != nil {
+		return nil, err
 	}
 
-	// Broadcast message
-	ws.broadcast <- marshaledMsg
+	return data, nil
 }
 
-func (ws *WebSocketServer) handleRejectFR(client *types.Client, rawMessage []byte) {
+func (ws *WebSocketServer) handleRejectFR(client *types.Client, rawMessage []byte) (any, error) {
 	message := types.IncomingFR{}
 
 	if err := json.Unmarshal(rawMessage, &message); err != nil {
-		log.Printf("Error unmarshaling message: %v\n", err)
-		return
+		return nil, newError(CodeValidationFailed, "invalid message: %v", err)
 	}
 
 	userId := client.UserID
 	friendId := message.SenderID
 	if userId == friendId {
-		log.Printf("User cannot reject their own friend request")
-		return
+		return nil, newError(CodeValidationFailed, "user cannot reject their own friend request")
 	}
 
 	// Check if users are already friends
 	areFriends, err := ws.storage.AreFriends(userId, friendId)
 	if err != nil {
-		log.Print("Failed to check if users are friend:" + err.Error())
-		return
+		return nil, fmt.Errorf("failed to check if users are friends: %w", err)
 	}
 
 	if areFriends {
-		log.Print("Users are already friends")
-		return
+		return nil, newError(CodeAlreadyFriends, "users are already friends")
 	}
 
 	// Check if friend request is already sent
 	isRequested, err := ws.storage.IsRequestedFriend(friendId, userId)
 	if err != nil {
-		log.Print("Failed to check if user is requested friend with the friend:" + err.Error())
-		return
+		return nil, fmt.Errorf("failed to check if user is requested friend with the friend: %w", err)
 	}
 
 	if !isRequested {
-		log.Print("There is no pending fr from this user")
-		return
+		return nil, newError(CodeNoPendingRequest, "there is no pending friend request from this user")
 	}
 
 	err = ws.storage.RejectFriendRequest(userId, friendId)
 	if err != nil {
-		log.Print("Failed to reject friend request:" + err.Error())
-		return
+		return nil, fmt.Errorf("failed to reject friend request: %w", err)
 	}
 
 	// Create data
@@ -125,75 +102,76 @@ func (ws *WebSocketServer) handleRejectFR(client *types.Client, rawMessage []byt
 		SenderID:   friendId,
 		ReceiverID: userId,
 	}
-	dataRaw, err := json.Marshal(data)
-	if err != nil {
-		log.Printf("Error marshaling message: %v\n", err)
-		return
-	}
 
-	// Create outgoing message
-	outgoingMsg := types.OutgoingBase{
-		Type:       "rejectFR",
-		Data:       dataRaw,
-		VerifyType: "userID",
-		VerifyIDs:  []int{userId},
-	}
-	marshaledMsg, err := json.Marshal(outgoingMsg)
-	if err != nil {
-		log.Printf("Error marshaling message: %v\n", err)
-		return
+	// Broadcast message
+	if err := ws.publishEvent("rejectFR", "userID", []int{userId}, data); err != nil {
+		return nil, err
 	}
 
-	// Broadcast message
-	ws.broadcast <- marshaledMsg
+	return data, nil
 }
 
-func (ws *WebSocketServer) handleSendFR(client *types.Client, rawMessage []byte) {
+func (ws *WebSocketServer) handleSendFR(client *types.Client, rawMessage []byte) (any, error) {
 	message := types.SendFR{}
 
 	if err := json.Unmarshal(rawMessage, &message); err != nil {
-		log.Printf("Error unmarshaling message: %v\n", err)
-		return
+		return nil, newError(CodeValidationFailed, "invalid message: %v", err)
 	}
 
 	userId := client.UserID
 	friendId := message.ReceiverID
 
 	if userId == friendId {
-		log.Print("User cannot send friend request to themselves")
-		return
+		return nil, newError(CodeValidationFailed, "user cannot send friend request to themselves")
+	}
+
+	exists, err := ws.storage.IsUserExisting(friendId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check if user exists: %w", err)
+	}
+
+	if !exists {
+		return nil, newError(CodeUserNotFound, "user %d does not exist", friendId)
 	}
 
 	// Check if users are already friends
 	areFriends, err := ws.storage.AreFriends(userId, friendId)
 	if err != nil {
-		log.Print("Failed to check if users are friend:" + err.Error())
-		return
+		return nil, fmt.Errorf("failed to check if users are friends: %w", err)
 	}
 
 	if areFriends {
-		log.Print("Users are already friends")
-		return
+		return nil, newError(CodeAlreadyFriends, "users are already friends")
 	}
 
 	// Check if friend request is already sent
-	isRequested1, err1 := ws.storage.IsRequestedFriend(userId, friendId)
-	isRequested2, err2 := ws.storage.IsRequestedFriend(friendId, userId)
+	isRequested1, err := ws.storage.IsRequestedFriend(userId, friendId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check if user is requested friend with the friend: %w", err)
+	}
 
-	if err1 != nil || err2 != nil {
-		log.Print("Failed to check if user is requested friend with the friend:" + err1.Error() + err2.Error())
-		return
+	isRequested2, err := ws.storage.IsRequestedFriend(friendId, userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check if user is requested friend with the friend: %w", err)
 	}
 
 	if isRequested1 || isRequested2 {
-		log.Print("User already requested to be friend with the friend")
-		return
+		return nil, newError(CodeAlreadyRequested, "a friend request between these users is already pending")
+	}
+
+	// Check if either user blocked the other
+	blocked, err := ws.storage.IsBlocked(userId, friendId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check if users are blocked: %w", err)
+	}
+
+	if blocked {
+		return nil, newError(CodeBlocked, "cannot send a friend request to this user")
 	}
 
 	err = ws.storage.SendFR(userId, friendId)
 	if err != nil {
-		log.Print("Failed to add friend:" + err.Error())
-		return
+		return nil, fmt.Errorf("failed to send friend request: %w", err)
 	}
 
 	// Create data
@@ -201,86 +179,118 @@ func (ws *WebSocketServer) handleSendFR(client *types.Client, rawMessage []byte)
 		SenderID:   userId,
 		ReceiverID: friendId,
 	}
-	dataRaw, err := json.Marshal(data)
-	if err != nil {
-		log.Printf("Error marshaling message: %v\n", err)
-		return
-	}
 
-	// Create outgoing message
-	outgoingMsg := types.OutgoingBase{
-		Type:       "sendFR",
-		Data:       dataRaw,
-		VerifyType: "userID",
-		VerifyIDs:  []int{userId, friendId},
-	}
-	marshaledMsg, err := json.Marshal(outgoingMsg)
-	if err != nil {
-		log.Printf("Error marshaling message: %v\n", err)
-		return
+	// Broadcast message
+	if err := ws.publishEvent("sendFR", "userID", []int{userId, friendId}, data); err != nil {
+		return nil, err
 	}
 
-	// Broadcast message
-	ws.broadcast <- marshaledMsg
+	return data, nil
 }
 
-func (ws *WebSocketServer) handleRemoveFriend(client *types.Client, rawMessage []byte) {
+func (ws *WebSocketServer) handleRemoveFriend(client *types.Client, rawMessage []byte) (any, error) {
 
 	message := types.RemoveFriend{}
 	if err := json.Unmarshal(rawMessage, &message); err != nil {
-		log.Print("Error unmarshaling message: " + err.Error())
+		return nil, newError(CodeValidationFailed, "invalid message: %v", err)
 	}
 
 	userId := client.UserID
 	friendId := message.FriendID
 
 	if userId == friendId {
-		log.Print("User cannot remove friend from themselves")
-		return
+		return nil, newError(CodeValidationFailed, "user cannot remove friend from themselves")
 	}
 
 	// Check if users are already friends
 	areFriends, err := ws.storage.AreFriends(userId, friendId)
 	if err != nil {
-		log.Print("Failed to check if users are friend:" + err.Error())
-		return
+		return nil, fmt.Errorf("failed to check if users are friends: %w", err)
 	}
 
 	if !areFriends {
-		log.Print("Users are not friends")
-		return
+		return nil, newError(CodeNotFriends, "users are not friends")
 	}
 
 	err = ws.storage.RemoveFriend(userId, friendId)
 	if err != nil {
-		log.Print("Failed to remove friend:" + err.Error())
-		return
+		return nil, fmt.Errorf("failed to remove friend: %w", err)
 	}
 
 	// Create data
 	data := types.RemoveFriendData{
+		UserId:   userId,
 		FriendID: friendId,
 	}
-	dataRaw, err := json.Marshal(data)
+
+	// Broadcast message
+	if err := ws.publishEvent("removeFriend", "userID", []int{userId}, data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (ws *WebSocketServer) handleBlock(client *types.Client, rawMessage []byte) (any, error) {
+	message := types.Block{}
+	if err := json.Unmarshal(rawMessage, &message); err != nil {
+		return nil, newError(CodeValidationFailed, "invalid message: %v", err)
+	}
+
+	userId := client.UserID
+	blockedId := message.UserID
+
+	if userId == blockedId {
+		return nil, newError(CodeValidationFailed, "user cannot block themselves")
+	}
+
+	exists, err := ws.storage.IsUserExisting(blockedId)
 	if err != nil {
-		log.Printf("Error marshaling message: %v\n", err)
-		return
+		return nil, fmt.Errorf("failed to check if user exists: %w", err)
+	}
+
+	if !exists {
+		return nil, newError(CodeUserNotFound, "user %d does not exist", blockedId)
 	}
 
-	// Create outgoing message
-	outgoingMsg := types.OutgoingBase{
-		Type:       "removeFriend",
-		Data:       dataRaw,
-		VerifyType: "userID",
-		VerifyIDs:  []int{userId},
+	// Blocking also ends the friendship and any pending friend request
+	if err := ws.storage.BlockUser(userId, blockedId); err != nil {
+		return nil, fmt.Errorf("failed to block user: %w", err)
 	}
 
-	marshaledMsg, err := json.Marshal(outgoingMsg)
+	data := types.BlockData{UserID: blockedId}
+
+	// Only the blocker's devices are told, the blocked user is not notified
+	if err := ws.publishEvent("block", "userID", []int{userId}, data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (ws *WebSocketServer) handleUnblock(client *types.Client, rawMessage []byte) (any, error) {
+	message := types.Block{}
+	if err := json.Unmarshal(rawMessage, &message); err != nil {
+		return nil, newError(CodeValidationFailed, "invalid message: %v", err)
+	}
+
+	userId := client.UserID
+	blockedId := message.UserID
+
+	removed, err := ws.storage.UnblockUser(userId, blockedId)
 	if err != nil {
-		log.Printf("Error marshaling message: %v\n", err)
-		return
+		return nil, fmt.Errorf("failed to unblock user: %w", err)
 	}
 
-	// Broadcast message
-	ws.broadcast <- marshaledMsg
+	if !removed {
+		return nil, newError(CodeValidationFailed, "user %d is not blocked", blockedId)
+	}
+
+	data := types.BlockData{UserID: blockedId}
+
+	if err := ws.publishEvent("unblock", "userID", []int{userId}, data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
 }
@@ -0,0 +1,406 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/carson2222/social-app/storage"
+	"github.com/carson2222/social-app/types"
+)
+
+// Actions of system messages.
+const (
+	systemMembersAdded  = "membersAdded"
+	systemMemberRemoved = "memberRemoved"
+	systemMemberLeft    = "memberLeft"
+	systemChatRenamed   = "chatRenamed"
+	systemRoleChanged   = "roleChanged"
+	systemAvatarChanged = "avatarChanged"
+)
+
+// maxChatNameLength is the longest group name, in characters.
+const maxChatNameLength = 100
+
+func isAdmin(role string) bool {
+	return role == types.ChatRoleOwner || role == types.ChatRoleAdmin
+}
+
+// groupRole returns the client's role in a group chat.
+func (ws *WebSocketServer) groupRole(client *types.Client, chatID int) (string, error) {
+	role, err := ws.storage.GetChatRole(client.UserID, chatID)
+	if errors.Is(err, storage.ErrNotInChat) {
+		return "", newError(CodeNotInChat, "you are not a member of chat %d", chatID)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get chat role: %w", err)
+	}
+
+	chat, err := ws.storage.GetChat(chatID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get chat: %w", err)
+	}
+
+	if !chat.IsGroup {
+		return "", newError(CodeValidationFailed, "chat %d is not a group", chatID)
+	}
+
+	return role, nil
+}
+
+// memberRole returns the role of another member of the chat.
+func (ws *WebSocketServer) memberRole(chatID, userID int) (string, error) {
+	role, err := ws.storage.GetChatRole(userID, chatID)
+	if errors.Is(err, storage.ErrNotInChat) {
+		return "", newError(CodeNotInChat, "user %d is not a member of chat %d", userID, chatID)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get chat role: %w", err)
+	}
+
+	return role, nil
+}
+
+// postSystemMessage stores a system message and broadcasts it to the chat as a
+// newMessage.
+func (ws *WebSocketServer) postSystemMessage(chatID int, content types.SystemMessage, attachments []types.Attachment) (types.NewMessageData, error) {
+	data, err := newSystemMessageData(chatID, content)
+	if err != nil {
+		return types.NewMessageData{}, err
+	}
+
+	attachmentIds := make([]int, len(attachments))
+	for i, attachment := range attachments {
+		attachmentIds[i] = attachment.ID
+	}
+
+	data.MessageID, err = ws.storage.NewSystemMessage(chatID, content.ActorID, data.Content, attachmentIds, data.SentAt)
+	if err != nil {
+		return types.NewMessageData{}, fmt.Errorf("failed to insert system message: %w", err)
+	}
+
+	if err := ws.publishSystemMessage(&data, attachments); err != nil {
+		return types.NewMessageData{}, err
+	}
+
+	return data, nil
+}
+
+// newSystemMessageData returns the data of a system message sent now, without
+// its message id.
+func newSystemMessageData(chatID int, content types.SystemMessage) (types.NewMessageData, error) {
+	rawContent, err := json.Marshal(content)
+	if err != nil {
+		return types.NewMessageData{}, err
+	}
+
+	return types.NewMessageData{
+		Content:  string(rawContent),
+		IsSystem: true,
+		ChatID:   chatID,
+		SenderID: content.ActorID,
+		SentAt:   time.Now(),
+	}, nil
+}
+
+// publishSystemMessage adds the stored attachments to data and broadcasts it
+// to the chat.
+func (ws *WebSocketServer) publishSystemMessage(data *types.NewMessageData, attachments []types.Attachment) error {
+	for _, attachment := range attachments {
+		attachment.MessageID = data.MessageID
+		attachment.ChatID = data.ChatID
+		data.Attachments = append(data.Attachments, attachment)
+	}
+
+	return ws.publishEvent("newMessage", "chatID", []int{data.ChatID}, data)
+}
+
+func (ws *WebSocketServer) handleAddMembers(client *types.Client, rawMessage []byte) (any, error) {
+	var request types.AddMembers
+
+	if err := json.Unmarshal(rawMessage, &request); err != nil {
+		return nil, newError(CodeValidationFailed, "invalid message: %v", err)
+	}
+
+	if len(request.UserIDs) == 0 {
+		return nil, newError(CodeValidationFailed, "no users to add")
+	}
+
+	role, err := ws.groupRole(client, request.ChatID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isAdmin(role) {
+		return nil, newError(CodeForbidden, "only chat admins can add members")
+	}
+
+	// Validate new members
+	for i, userID := range request.UserIDs {
+		if slices.Contains(request.UserIDs[:i], userID) {
+			return nil, newError(CodeValidationFailed, "user %d is listed twice", userID)
+		}
+
+		exists, err := ws.storage.IsUserExisting(userID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check if user exists: %w", err)
+		}
+
+		if !exists {
+			return nil, newError(CodeUserNotFound, "user %d does not exist", userID)
+		}
+
+		err = ws.storage.IsUserInChat(userID, request.ChatID)
+		if err == nil {
+			return nil, newError(CodeValidationFailed, "user %d is already a member of chat %d", userID, request.ChatID)
+		}
+		if !errors.Is(err, storage.ErrNotInChat) {
+			return nil, fmt.Errorf("failed to check if user is in chat: %w", err)
+		}
+	}
+
+	if err := ws.storage.AddChatMembers(request.ChatID, request.UserIDs); err != nil {
+		return nil, fmt.Errorf("failed to add chat members: %w", err)
+	}
+
+	// Subscribe the new members first, so they receive the system message
+	if err := ws.publishMembershipChange(request.ChatID, request.UserIDs, nil, membershipMembersAdded); err != nil {
+		log.Printf("Error publishing membership change: %v\n", err)
+	}
+
+	return ws.postSystemMessage(request.ChatID, types.SystemMessage{
+		Action:  systemMembersAdded,
+		ActorID: client.UserID,
+		UserIDs: request.UserIDs,
+	}, nil)
+}
+
+func (ws *WebSocketServer) handleRemoveMember(client *types.Client, rawMessage []byte) (any, error) {
+	var request types.RemoveMember
+
+	if err := json.Unmarshal(rawMessage, &request); err != nil {
+		return nil, newError(CodeValidationFailed, "invalid message: %v", err)
+	}
+
+	if request.UserID == client.UserID {
+		return nil, newError(CodeValidationFailed, "use leaveChat to leave a chat")
+	}
+
+	role, err := ws.groupRole(client, request.ChatID)
+	if err != nil {
+		return nil, err
+	}
+
+	memberRole, err := ws.memberRole(request.ChatID, request.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Admins remove members, only the owner removes admins and nobody removes the owner
+	allowed := isAdmin(role) && memberRole == types.ChatRoleMember ||
+		role == types.ChatRoleOwner && memberRole == types.ChatRoleAdmin
+	if !allowed {
+		return nil, newError(CodeForbidden, "you can not remove user %d from chat %d", request.UserID, request.ChatID)
+	}
+
+	if _, err := ws.storage.RemoveChatMember(request.ChatID, request.UserID); err != nil {
+		return nil, fmt.Errorf("failed to remove chat member: %w", err)
+	}
+
+	// The removed member is unsubscribed after receiving the system message
+	data, err := ws.postSystemMessage(request.ChatID, types.SystemMessage{
+		Action:  systemMemberRemoved,
+		ActorID: client.UserID,
+		UserIDs: []int{request.UserID},
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ws.publishMembershipChange(request.ChatID, nil, []int{request.UserID}, membershipMemberRemoved); err != nil {
+		log.Printf("Error publishing membership change: %v\n", err)
+	}
+
+	return data, nil
+}
+
+func (ws *WebSocketServer) handleLeaveChat(client *types.Client, rawMessage []byte) (any, error) {
+	var request types.LeaveChat
+
+	if err := json.Unmarshal(rawMessage, &request); err != nil {
+		return nil, newError(CodeValidationFailed, "invalid message: %v", err)
+	}
+
+	if _, err := ws.groupRole(client, request.ChatID); err != nil {
+		return nil, err
+	}
+
+	newOwner, err := ws.storage.RemoveChatMember(request.ChatID, client.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to leave chat: %w", err)
+	}
+
+	data, err := ws.postSystemMessage(request.ChatID, types.SystemMessage{
+		Action:  systemMemberLeft,
+		ActorID: client.UserID,
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ownership passed on to another member
+	if newOwner != 0 {
+		_, err := ws.postSystemMessage(request.ChatID, types.SystemMessage{
+			Action:  systemRoleChanged,
+			ActorID: client.UserID,
+			UserIDs: []int{newOwner},
+			Role:    types.ChatRoleOwner,
+		}, nil)
+		if err != nil {
+			log.Printf("Error posting ownership change: %v\n", err)
+		}
+	}
+
+	if err := ws.publishMembershipChange(request.ChatID, nil, []int{client.UserID}, membershipMemberLeft); err != nil {
+		log.Printf("Error publishing membership change: %v\n", err)
+	}
+
+	return data, nil
+}
+
+func (ws *WebSocketServer) handleRenameChat(client *types.Client, rawMessage []byte) (any, error) {
+	var request types.RenameChat
+
+	if err := json.Unmarshal(rawMessage, &request); err != nil {
+		return nil, newError(CodeValidationFailed, "invalid message: %v", err)
+	}
+
+	name := strings.TrimSpace(request.Name)
+	if name == "" || utf8.RuneCountInString(name) > maxChatNameLength {
+		return nil, newError(CodeValidationFailed, "chat name must be between 1 and %d characters", maxChatNameLength)
+	}
+
+	role, err := ws.groupRole(client, request.ChatID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isAdmin(role) {
+		return nil, newError(CodeForbidden, "only chat admins can rename the chat")
+	}
+
+	if err := ws.storage.RenameChat(request.ChatID, name); err != nil {
+		return nil, fmt.Errorf("failed to rename chat: %w", err)
+	}
+
+	return ws.postSystemMessage(request.ChatID, types.SystemMessage{
+		Action:  systemChatRenamed,
+		ActorID: client.UserID,
+		Name:    name,
+	}, nil)
+}
+
+func (ws *WebSocketServer) handlePromoteMember(client *types.Client, rawMessage []byte) (any, error) {
+	var request types.PromoteMember
+
+	if err := json.Unmarshal(rawMessage, &request); err != nil {
+		return nil, newError(CodeValidationFailed, "invalid message: %v", err)
+	}
+
+	switch request.Role {
+	case types.ChatRoleOwner, types.ChatRoleAdmin, types.ChatRoleMember:
+	default:
+		return nil, newError(CodeValidationFailed, "unknown role %q", request.Role)
+	}
+
+	if request.UserID == client.UserID {
+		return nil, newError(CodeValidationFailed, "you can not change your own role")
+	}
+
+	role, err := ws.groupRole(client, request.ChatID)
+	if err != nil {
+		return nil, err
+	}
+
+	if role != types.ChatRoleOwner {
+		return nil, newError(CodeForbidden, "only the chat owner can change roles")
+	}
+
+	if _, err := ws.memberRole(request.ChatID, request.UserID); err != nil {
+		return nil, err
+	}
+
+	// Handing over ownership makes the current owner an admin
+	if err := ws.storage.SetChatRole(request.ChatID, request.UserID, request.Role); err != nil {
+		return nil, fmt.Errorf("failed to change role: %w", err)
+	}
+
+	return ws.postSystemMessage(request.ChatID, types.SystemMessage{
+		Action:  systemRoleChanged,
+		ActorID: client.UserID,
+		UserIDs: []int{request.UserID},
+		Role:    request.Role,
+	}, nil)
+}
+
+func (ws *WebSocketServer) handleSetChatAvatar(client *types.Client, rawMessage []byte) (any, error) {
+	var request types.SetChatAvatar
+
+	if err := json.Unmarshal(rawMessage, &request); err != nil {
+		return nil, newError(CodeValidationFailed, "invalid message: %v", err)
+	}
+
+	role, err := ws.groupRole(client, request.ChatID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isAdmin(role) {
+		return nil, newError(CodeForbidden, "only chat admins can change the chat picture")
+	}
+
+	// The picture is sent with the system message, which makes it visible to members
+	var attachments []types.Attachment
+	if request.AttachmentID != 0 {
+		attachment, err := ws.storage.GetAttachment(request.AttachmentID)
+		if errors.Is(err, storage.ErrAttachmentNotFound) || (err == nil && (attachment.UploaderID != client.UserID || attachment.MessageID != 0)) {
+			return nil, newError(CodeAttachmentNotFound, "attachment %d does not exist or was already sent", request.AttachmentID)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get attachment: %w", err)
+		}
+
+		if !strings.HasPrefix(attachment.MimeType, "image/") {
+			return nil, newError(CodeValidationFailed, "the chat picture must be an image")
+		}
+		attachments = append(attachments, attachment)
+	}
+
+	data, err := newSystemMessageData(request.ChatID, types.SystemMessage{
+		Action:  systemAvatarChanged,
+		ActorID: client.UserID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The picture and the system message announcing it are stored together
+	data.MessageID, err = ws.storage.SetChatAvatar(request.ChatID, client.UserID, data.Content, request.AttachmentID, data.SentAt)
+	if errors.Is(err, storage.ErrAttachmentNotFound) {
+		return nil, newError(CodeAttachmentNotFound, "attachment %d was already sent", request.AttachmentID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to set chat picture: %w", err)
+	}
+
+	if err := ws.publishSystemMessage(&data, attachments); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
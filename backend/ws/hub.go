@@ -0,0 +1,252 @@
+package ws
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/carson2222/social-app/types"
+	"github.com/gorilla/websocket"
+)
+
+// hub owns the set of connected clients. Besides the plain client set it keeps
+// userID -> clients and chatID -> clients indexes, so a broadcast only visits
+// its recipients instead of every open connection, and a sessionID -> clients
+// index to close the connections of revoked sessions.
+//
+// client.ChatIDs and client.Send are owned by the hub: ChatIDs is only read or
+// written under mu, and Send is only pushed to under mu and closed by
+// unregister.
+type hub struct {
+	mu        sync.RWMutex
+	clients   map[*types.Client]bool
+	byUser    map[int]map[*types.Client]bool
+	byChat    map[int]map[*types.Client]bool
+	bySession map[int]map[*types.Client]bool
+	policy    types.OverflowPolicy
+}
+
+func newHub(policy types.OverflowPolicy) *hub {
+	return &hub{
+		clients:   make(map[*types.Client]bool),
+		byUser:    make(map[int]map[*types.Client]bool),
+		byChat:    make(map[int]map[*types.Client]bool),
+		bySession: make(map[int]map[*types.Client]bool),
+		policy:    policy,
+	}
+}
+
+func addToIndex(index map[int]map[*types.Client]bool, id int, client *types.Client) {
+	clients, ok := index[id]
+	if !ok {
+		clients = make(map[*types.Client]bool)
+		index[id] = clients
+	}
+	clients[client] = true
+}
+
+func removeFromIndex(index map[int]map[*types.Client]bool, id int, client *types.Client) {
+	clients, ok := index[id]
+	if !ok {
+		return
+	}
+	delete(clients, client)
+	if len(clients) == 0 {
+		delete(index, id)
+	}
+}
+
+func (h *hub) register(client *types.Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.clients[client] = true
+	addToIndex(h.byUser, client.UserID, client)
+	addToIndex(h.bySession, client.SessionID, client)
+	for chatID := range client.ChatIDs {
+		addToIndex(h.byChat, chatID, client)
+	}
+}
+
+// unregister removes the client and closes its Send queue, which makes the
+// writer send a close frame with code and reason. It reports whether the client
+// was still registered, so it is safe to call from every goroutine that
+// notices the connection is gone.
+func (h *hub) unregister(client *types.Client, code int, reason string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.clients[client] {
+		return false
+	}
+
+	client.CloseCode = code
+	client.CloseReason = reason
+
+	delete(h.clients, client)
+	removeFromIndex(h.byUser, client.UserID, client)
+	removeFromIndex(h.bySession, client.SessionID, client)
+	for chatID := range client.ChatIDs {
+		removeFromIndex(h.byChat, chatID, client)
+	}
+	client.Send.Close()
+
+	return true
+}
+
+// recipients returns the clients addressed by verifyType/ids, each at most once.
+// The caller must hold mu.
+func (h *hub) recipients(verifyType string, ids []int) map[*types.Client]bool {
+	var index map[int]map[*types.Client]bool
+	switch verifyType {
+	case "userID":
+		index = h.byUser
+	case "chatID":
+		index = h.byChat
+	default:
+		return nil
+	}
+
+	recipients := make(map[*types.Client]bool)
+	for _, id := range ids {
+		for client := range index[id] {
+			recipients[client] = true
+		}
+	}
+
+	return recipients
+}
+
+// push queues frame on client according to the overflow policy and records
+// the outcome. It reports false if the client overflowed and must be dropped.
+// The caller must hold mu.
+func (h *hub) push(client *types.Client, frame types.Frame) bool {
+	switch client.Send.Push(frame, h.policy) {
+	case types.PushCoalesced:
+		metrics.Add("queue_coalesced", 1)
+	case types.PushDropped:
+		metrics.Add("queue_drops", 1)
+	case types.PushOverflow:
+		return false
+	}
+	return true
+}
+
+// dropSlow unregisters clients that overflowed their queue.
+func (h *hub) dropSlow(slow []*types.Client) {
+	for _, client := range slow {
+		if h.unregister(client, websocket.CloseTryAgainLater, "slow consumer") {
+			metrics.Add("slow_consumer_disconnects", 1)
+		}
+	}
+}
+
+// deliver queues frame on every recipient without blocking. Clients that
+// overflow their queue are unregistered and returned.
+func (h *hub) deliver(verifyType string, ids, excludeIDs []int, frame types.Frame) []*types.Client {
+	var slow []*types.Client
+
+	h.mu.RLock()
+	for client := range h.recipients(verifyType, ids) {
+		if slices.Contains(excludeIDs, client.UserID) {
+			continue
+		}
+		if !h.push(client, frame) {
+			slow = append(slow, client)
+		}
+	}
+	h.mu.RUnlock()
+
+	h.dropSlow(slow)
+
+	return slow
+}
+
+// sendTo queues frame on a single client and reports whether it was queued.
+func (h *hub) sendTo(client *types.Client, frame types.Frame) bool {
+	h.mu.RLock()
+	registered := h.clients[client]
+	queued := registered && h.push(client, frame)
+	h.mu.RUnlock()
+
+	if registered && !queued {
+		h.dropSlow([]*types.Client{client})
+	}
+
+	return queued
+}
+
+// queueDepth returns the total and the largest number of frames waiting in
+// client queues.
+func (h *hub) queueDepth() (int, int) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	total, largest := 0, 0
+	for client := range h.clients {
+		depth := client.Send.Len()
+		total += depth
+		largest = max(largest, depth)
+	}
+
+	return total, largest
+}
+
+// count returns the number of registered clients.
+func (h *hub) count() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return len(h.clients)
+}
+
+// applyMembership updates the chat membership of every connected device of
+// the added and removed users.
+func (h *hub) applyMembership(chatID int, added, removed []int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, userID := range added {
+		for client := range h.byUser[userID] {
+			client.ChatIDs[chatID] = true
+			addToIndex(h.byChat, chatID, client)
+		}
+	}
+
+	for _, userID := range removed {
+		for client := range h.byUser[userID] {
+			delete(client.ChatIDs, chatID)
+			removeFromIndex(h.byChat, chatID, client)
+		}
+	}
+}
+
+// closeSessions unregisters every client authenticated with one of sessionIDs.
+func (h *hub) closeSessions(sessionIDs []int) {
+	var revoked []*types.Client
+
+	h.mu.RLock()
+	for _, sessionID := range sessionIDs {
+		for client := range h.bySession[sessionID] {
+			revoked = append(revoked, client)
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, client := range revoked {
+		h.unregister(client, websocket.ClosePolicyViolation, "session revoked")
+	}
+}
+
+// closeAll unregisters every client.
+func (h *hub) closeAll(code int, reason string) {
+	h.mu.RLock()
+	clients := make([]*types.Client, 0, len(h.clients))
+	for client := range h.clients {
+		clients = append(clients, client)
+	}
+	h.mu.RUnlock()
+
+	for _, client := range clients {
+		h.unregister(client, code, reason)
+	}
+}
@@ -0,0 +1,112 @@
+package ws
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/carson2222/social-app/types"
+	"github.com/gorilla/websocket"
+)
+
+func newTestClient(userID, sessionID int, chatIDs ...int) *types.Client {
+	client := &types.Client{
+		UserID:    userID,
+		SessionID: sessionID,
+		ChatIDs:   make(map[int]bool),
+		Send:      types.NewSendQueue(8),
+	}
+	for _, chatID := range chatIDs {
+		client.ChatIDs[chatID] = true
+	}
+	return client
+}
+
+func TestHubDeliver(t *testing.T) {
+	h := newHub(types.OverflowDropOldest)
+
+	a := newTestClient(1, 1, 10)
+	b := newTestClient(2, 2, 10)
+	c := newTestClient(3, 3)
+	h.register(a)
+	h.register(b)
+	h.register(c)
+
+	h.deliver("chatID", []int{10}, []int{2}, types.Frame{Data: []byte("x")})
+	if a.Send.Len() != 1 || b.Send.Len() != 0 || c.Send.Len() != 0 {
+		t.Fatalf("queue lengths = %d, %d, %d, want 1, 0, 0", a.Send.Len(), b.Send.Len(), c.Send.Len())
+	}
+
+	h.applyMembership(10, []int{3}, []int{1})
+	h.deliver("chatID", []int{10}, nil, types.Frame{Data: []byte("y")})
+	if a.Send.Len() != 1 || b.Send.Len() != 1 || c.Send.Len() != 1 {
+		t.Fatalf("queue lengths = %d, %d, %d, want 1, 1, 1", a.Send.Len(), b.Send.Len(), c.Send.Len())
+	}
+
+	h.closeSessions([]int{2})
+	if !b.Send.Closed() || b.CloseCode != websocket.ClosePolicyViolation {
+		t.Fatalf("client of revoked session not closed, code %d", b.CloseCode)
+	}
+	if h.unregister(b, websocket.CloseNormalClosure, "") {
+		t.Fatal("unregister of a removed client reported true")
+	}
+
+	h.closeAll(websocket.CloseTryAgainLater, "missed events")
+	if n := h.count(); n != 0 || !a.Send.Closed() || a.CloseCode != websocket.CloseTryAgainLater {
+		t.Fatalf("%d clients left after closeAll, close code %d", n, a.CloseCode)
+	}
+}
+
+// TestHubConcurrent runs every hub operation at once; run it with -race.
+func TestHubConcurrent(t *testing.T) {
+	const (
+		users  = 8
+		rounds = 200
+		chatID = 1
+	)
+
+	h := newHub(types.OverflowDisconnect)
+
+	var wg sync.WaitGroup
+	for userID := 1; userID <= users; userID++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range rounds {
+				client := newTestClient(userID, userID*rounds+i, chatID)
+				h.register(client)
+
+				// Drain the queue like the writer goroutine does
+				done := make(chan struct{})
+				go func() {
+					defer close(done)
+					for !client.Send.Closed() {
+						client.Send.Pop()
+						<-client.Send.Ready()
+					}
+				}()
+
+				h.applyMembership(chatID+1, []int{userID}, nil)
+				h.deliver("chatID", []int{chatID, chatID + 1}, nil, types.Frame{Data: []byte("m")})
+				h.deliver("userID", []int{userID, userID%users + 1}, []int{userID}, types.Frame{Data: []byte("u")})
+				h.sendTo(client, types.Frame{Data: []byte("s")})
+				h.queueDepth()
+				h.applyMembership(chatID+1, nil, []int{userID})
+
+				if i%2 == 0 {
+					h.closeSessions([]int{client.SessionID})
+				} else {
+					h.unregister(client, websocket.CloseNormalClosure, "")
+				}
+				<-done
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := h.count(); n != 0 {
+		t.Fatalf("%d clients left registered", n)
+	}
+	if len(h.byUser) != 0 || len(h.byChat) != 0 || len(h.bySession) != 0 {
+		t.Fatalf("indexes not empty: %d users, %d chats, %d sessions", len(h.byUser), len(h.byChat), len(h.bySession))
+	}
+}
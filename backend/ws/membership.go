@@ -0,0 +1,42 @@
+package ws
+
+import (
+	"encoding/json"
+
+	"github.com/carson2222/social-app/types"
+)
+
+// Reasons sent in chatMembershipChanged events.
+const (
+	membershipChatCreated   = "chatCreated"
+	membershipMembersAdded  = "membersAdded"
+	membershipMemberRemoved = "memberRemoved"
+	membershipMemberLeft    = "memberLeft"
+)
+
+// publishMembershipChange tells every connected device of the affected users
+// that their membership of chatID changed. The hub applies the change when the
+// event is broadcast, before it is delivered, so events sent to the chat after
+// this call reach the new members and skip the removed ones.
+func (ws *WebSocketServer) publishMembershipChange(chatID int, added, removed []int, reason string) error {
+	data := types.ChatMembershipChangedData{
+		ChatID:  chatID,
+		Added:   added,
+		Removed: removed,
+		Reason:  reason,
+	}
+
+	affected := append(append([]int{}, added...), removed...)
+	return ws.publishEvent("chatMembershipChanged", "userID", affected, data)
+}
+
+// applyMembershipEvent updates the hub for a chatMembershipChanged broadcast.
+func (ws *WebSocketServer) applyMembershipEvent(outgoingMsg types.OutgoingBase) error {
+	var data types.ChatMembershipChangedData
+	if err := json.Unmarshal(outgoingMsg.Data, &data); err != nil {
+		return err
+	}
+
+	ws.hub.applyMembership(data.ChatID, data.Added, data.Removed)
+	return nil
+}
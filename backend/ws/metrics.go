@@ -0,0 +1,54 @@
+package ws
+
+import "expvar"
+
+// metrics is published on /debug/vars under "ws".
+var metrics = expvar.NewMap("ws")
+
+type Stats struct {
+	Connections             int   `json:"connections"`
+	Reaped                  int64 `json:"reaped"`
+	QueuedFrames            int   `json:"queued_frames"`
+	LargestQueue            int   `json:"largest_queue"`
+	QueueDrops              int64 `json:"queue_drops"`
+	QueueCoalesced          int64 `json:"queue_coalesced"`
+	SlowConsumerDisconnects int64 `json:"slow_consumer_disconnects"`
+}
+
+func counter(name string) int64 {
+	if v, ok := metrics.Get(name).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+// Stats reports the number of open connections, how many connections were
+// reaped because they stopped answering pings or stalled on writes, and the
+// state of the per-client send queues.
+func (ws *WebSocketServer) Stats() Stats {
+	queued, largest := ws.hub.queueDepth()
+
+	return Stats{
+		Connections:             ws.hub.count(),
+		Reaped:                  counter("reaped_connections"),
+		QueuedFrames:            queued,
+		LargestQueue:            largest,
+		QueueDrops:              counter("queue_drops"),
+		QueueCoalesced:          counter("queue_coalesced"),
+		SlowConsumerDisconnects: counter("slow_consumer_disconnects"),
+	}
+}
+
+func (ws *WebSocketServer) publishMetrics() {
+	metrics.Set("connections", expvar.Func(func() any {
+		return ws.hub.count()
+	}))
+	metrics.Set("queue_depth", expvar.Func(func() any {
+		queued, largest := ws.hub.queueDepth()
+		return map[string]int{"total": queued, "largest": largest}
+	}))
+
+	for _, name := range []string{"reaped_connections", "queue_drops", "queue_coalesced", "slow_consumer_disconnects"} {
+		metrics.Add(name, 0)
+	}
+}
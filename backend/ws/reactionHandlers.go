@@ -0,0 +1,98 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"unicode/utf8"
+
+	"github.com/carson2222/social-app/storage"
+	"github.com/carson2222/social-app/types"
+)
+
+// maxReactionLength limits reactions to a short emoji or shortcode, in characters.
+const maxReactionLength = 32
+
+func (ws *WebSocketServer) handleAddReaction(client *types.Client, rawMessage []byte) (any, error) {
+	return ws.changeReaction(client, rawMessage, true)
+}
+
+func (ws *WebSocketServer) handleRemoveReaction(client *types.Client, rawMessage []byte) (any, error) {
+	return ws.changeReaction(client, rawMessage, false)
+}
+
+func (ws *WebSocketServer) changeReaction(client *types.Client, rawMessage []byte, add bool) (any, error) {
+	var request types.Reaction
+
+	if err := json.Unmarshal(rawMessage, &request); err != nil {
+		return nil, newError(CodeValidationFailed, "invalid message: %v", err)
+	}
+
+	// Validate reaction
+	if request.Reaction == "" || utf8.RuneCountInString(request.Reaction) > maxReactionLength {
+		return nil, newError(CodeValidationFailed, "reaction must be between 1 and %d characters", maxReactionLength)
+	}
+
+	allowed := ws.config.Reactions.Allowed
+	if add && len(allowed) > 0 && !slices.Contains(allowed, request.Reaction) {
+		return nil, newError(CodeValidationFailed, "reaction %q is not allowed", request.Reaction)
+	}
+
+	message, err := ws.storage.GetMessage(request.MessageID)
+	if errors.Is(err, storage.ErrMessageNotFound) {
+		return nil, newError(CodeMessageNotFound, "message %d does not exist", request.MessageID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message: %w", err)
+	}
+
+	// Check if user is in the message's chat
+	if err := ws.storage.IsUserInChat(client.UserID, message.ChatID); err != nil {
+		if errors.Is(err, storage.ErrNotInChat) {
+			return nil, newError(CodeNotInChat, "you are not a member of chat %d", message.ChatID)
+		}
+		return nil, fmt.Errorf("failed to check if user is in chat: %w", err)
+	}
+
+	var changed bool
+	if add {
+		changed, err = ws.storage.AddReaction(client.UserID, message.MessageID, request.Reaction, ws.config.Reactions.MaxDistinct)
+	} else {
+		changed, err = ws.storage.RemoveReaction(client.UserID, message.MessageID, request.Reaction)
+	}
+
+	switch {
+	case errors.Is(err, storage.ErrMessageNotFound):
+		return nil, newError(CodeMessageNotFound, "message %d was deleted", message.MessageID)
+	case errors.Is(err, storage.ErrTooManyReactions):
+		return nil, newError(CodeValidationFailed, "message %d already has %d different reactions", message.MessageID, ws.config.Reactions.MaxDistinct)
+	case err != nil:
+		return nil, fmt.Errorf("failed to change reaction: %w", err)
+	}
+
+	reactions, err := ws.storage.GetReactions(message.MessageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get reactions: %w", err)
+	}
+
+	data := types.ReactionChangedData{
+		MessageID: message.MessageID,
+		ChatID:    message.ChatID,
+		UserID:    client.UserID,
+		Reaction:  request.Reaction,
+		Added:     add,
+		Reactions: reactions,
+	}
+
+	// Nothing to tell the chat if the reaction was already there (or gone)
+	if !changed {
+		return data, nil
+	}
+
+	if err := ws.publishEvent("reactionChanged", "chatID", []int{message.ChatID}, data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
@@ -0,0 +1,30 @@
+package ws
+
+import (
+	"encoding/json"
+
+	"github.com/carson2222/social-app/types"
+)
+
+// RevokeSessions closes every WebSocket authenticated with one of sessionIDs,
+// on every instance, and tells the user's remaining devices about it.
+func (ws *WebSocketServer) RevokeSessions(userID int, sessionIDs []int) error {
+	if len(sessionIDs) == 0 {
+		return nil
+	}
+
+	data := types.SessionsRevokedData{SessionIDs: sessionIDs}
+	return ws.publishEvent("sessionsRevoked", "userID", []int{userID}, data)
+}
+
+// applySessionsRevoked unregisters the clients of a sessionsRevoked broadcast,
+// so the event itself only reaches the devices that stay signed in.
+func (ws *WebSocketServer) applySessionsRevoked(outgoingMsg types.OutgoingBase) error {
+	var data types.SessionsRevokedData
+	if err := json.Unmarshal(outgoingMsg.Data, &data); err != nil {
+		return err
+	}
+
+	ws.hub.closeSessions(data.SessionIDs)
+	return nil
+}
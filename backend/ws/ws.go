@@ -2,33 +2,45 @@ package ws
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
-	"sync"
+	"slices"
+	"strconv"
+	"time"
 
+	"github.com/carson2222/social-app/config"
 	"github.com/carson2222/social-app/storage"
 	"github.com/carson2222/social-app/types"
 	"github.com/carson2222/social-app/utils"
 	"github.com/gorilla/websocket"
 )
 
+// handlerFunc handles one incoming message type. The returned value is sent
+// back to the client in an ack frame; the returned error in an error frame.
+type handlerFunc func(client *types.Client, rawMessage []byte) (any, error)
+
 type WebSocketServer struct {
-	clients   map[*types.Client]bool                 // Registered clients
-	broadcast chan []byte                            // Broadcast channel for all messages
-	handlers  map[string]func(*types.Client, []byte) // Event handlers
-	storage   *storage.PostgresStore
+	hub      *hub                   // Registered clients
+	fanOut   FanOut                 // Broadcasts events to every instance
+	handlers map[string]handlerFunc // Event handlers
+	storage  storage.Storage
+	config   *config.Config
 }
 
-func NewWebSocketServer(storage *storage.PostgresStore) *WebSocketServer {
+func NewWebSocketServer(cfg *config.Config, storage storage.Storage, fanOut FanOut) *WebSocketServer {
 	wsServer := &WebSocketServer{
-		clients:   make(map[*types.Client]bool),
-		broadcast: make(chan []byte),
-		handlers:  make(map[string]func(*types.Client, []byte)),
-		storage:   storage,
+		config:   cfg,
+		hub:      newHub(types.OverflowPolicy(cfg.WebSocket.OverflowPolicy)),
+		fanOut:   fanOut,
+		handlers: make(map[string]handlerFunc),
+		storage:  storage,
 	}
 
 	wsServer.registerHandlers()
+	wsServer.publishMetrics()
 
 	go wsServer.BroadcastMessages()
 
@@ -39,12 +51,22 @@ func (ws *WebSocketServer) ServerWebSocket(w http.ResponseWriter, r *http.Reques
 	fmt.Println(r.Host)
 
 	// Check if the user is authenticated
-	userId, err := ws.authenticateWebSocket(r)
-	if err != nil || userId == -1 {
+	session, err := ws.authenticateWebSocket(r)
+	if err != nil {
 		log.Println("failed to authenticate websocket", err)
 		utils.WriteJSON(w, http.StatusUnauthorized, "Unauthorized")
 		return
 	}
+	userId := session.UserID
+
+	// Cursor of the last event the client has seen, if it is resuming
+	since, resuming := int64(0), r.URL.Query().Has("since")
+	if resuming {
+		if since, err = strconv.ParseInt(r.URL.Query().Get("since"), 10, 64); err != nil || since < 0 {
+			utils.WriteJSON(w, http.StatusBadRequest, "Invalid since cursor")
+			return
+		}
+	}
 
 	// Get the user's chat IDs
 	chatIDs, err := ws.storage.GetUserChats(userId)
@@ -64,89 +86,252 @@ func (ws *WebSocketServer) ServerWebSocket(w http.ResponseWriter, r *http.Reques
 	}
 
 	client := &types.Client{
-		Conn:    conn,
-		UserID:  userId,
-		ChatIDs: chatIDs,
-		Send:    make(chan []byte),
+		Conn:      conn,
+		UserID:    userId,
+		SessionID: session.ID,
+		ChatIDs:   chatIDs,
+		Send:      types.NewSendQueue(ws.config.WebSocket.SendQueueSize),
 	}
 
-	var clientsMu sync.Mutex
-	clientsMu.Lock()
-	ws.clients[client] = true
-	clientsMu.Unlock()
+	// Live events are queued from now on, so nothing falls between replay and live delivery
+	ws.hub.register(client)
+
+	skipThrough := int64(0)
+	if resuming {
+		if skipThrough, err = ws.replay(client, since); err != nil {
+			log.Printf("Error replaying events for user %d: %v", userId, err)
+			ws.hub.unregister(client, websocket.CloseInternalServerErr, "replay failed")
+			conn.Close()
+			return
+		}
+	}
 
 	go ws.handleReads(client)
-	go ws.handleWrites(client)
+	go ws.handleWrites(client, skipThrough)
 }
 
 func (ws *WebSocketServer) registerHandlers() {
-	ws.handlers = make(map[string]func(*types.Client, []byte))
+	ws.handlers = make(map[string]handlerFunc)
 	ws.handlers["newMessage"] = ws.handleMessage
 	ws.handlers["newChat"] = ws.handleNewChat
+	ws.handlers["openDirectChat"] = ws.handleOpenDirectChat
+	ws.handlers["addMembers"] = ws.handleAddMembers
+	ws.handlers["removeMember"] = ws.handleRemoveMember
+	ws.handlers["leaveChat"] = ws.handleLeaveChat
+	ws.handlers["renameChat"] = ws.handleRenameChat
+	ws.handlers["promoteMember"] = ws.handlePromoteMember
+	ws.handlers["setChatAvatar"] = ws.handleSetChatAvatar
+	ws.handlers["markRead"] = ws.handleMarkRead
+	ws.handlers["editMessage"] = ws.handleEditMessage
+	ws.handlers["deleteMessage"] = ws.handleDeleteMessage
+	ws.handlers["addReaction"] = ws.handleAddReaction
+	ws.handlers["removeReaction"] = ws.handleRemoveReaction
 
 	ws.handlers["acceptFR"] = ws.handleAcceptFR
 	ws.handlers["rejectFR"] = ws.handleRejectFR
 	ws.handlers["sendFR"] = ws.handleSendFR
 	ws.handlers["removeFriend"] = ws.handleRemoveFriend
+	ws.handlers["block"] = ws.handleBlock
+	ws.handlers["unblock"] = ws.handleUnblock
 }
 
 func (ws *WebSocketServer) handleReads(client *types.Client) {
-	defer func() {
-		delete(ws.clients, client)
-		client.Conn.Close()
-	}()
+	pongWait := ws.config.WebSocket.PongWait
+
+	client.Conn.SetReadLimit(ws.config.WebSocket.MaxMessageSize)
+	client.Conn.SetReadDeadline(time.Now().Add(pongWait))
+	client.Conn.SetPongHandler(func(string) error {
+		return client.Conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
 
 	for {
 		_, messageBytes, err := client.Conn.ReadMessage()
 		if err != nil {
-			log.Printf("Error reading message: %v", err)
-			break
+			ws.handleReadError(client, err)
+			return
 		}
 
-		var baseIncoming types.IncomingBase
-		if err := json.Unmarshal(messageBytes, &baseIncoming); err != nil {
-			log.Printf("error unmarshaling incoming base message: %v", err)
-			continue
-		}
+		client.Conn.SetReadDeadline(time.Now().Add(pongWait))
+		ws.handleIncoming(client, messageBytes)
+	}
+}
 
-		// Check if we have a handler for the incoming message type
-		if handler, ok := ws.handlers[baseIncoming.Type]; ok {
-			handler(client, messageBytes)
-		} else {
-			log.Printf("No handler for message type: %s", baseIncoming.Type)
+// handleReadError unregisters a client whose connection can no longer be read.
+func (ws *WebSocketServer) handleReadError(client *types.Client, err error) {
+	var netErr net.Error
+	switch {
+	case errors.As(err, &netErr) && netErr.Timeout():
+		if ws.hub.unregister(client, websocket.CloseGoingAway, "ping timeout") {
+			metrics.Add("reaped_connections", 1)
+			log.Printf("Reaped unresponsive connection of user %d", client.UserID)
 		}
+	case errors.Is(err, websocket.ErrReadLimit):
+		ws.hub.unregister(client, websocket.CloseMessageTooBig, "message too big")
+	case websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway):
+		ws.hub.unregister(client, websocket.CloseNormalClosure, "")
+	default:
+		log.Printf("Error reading message: %v", err)
+		ws.hub.unregister(client, websocket.CloseProtocolError, "read error")
+	}
+}
+
+// handleIncoming dispatches one incoming message and answers the client with
+// an ack or an error frame.
+func (ws *WebSocketServer) handleIncoming(client *types.Client, messageBytes []byte) {
+	var baseIncoming types.IncomingBase
+	if err := json.Unmarshal(messageBytes, &baseIncoming); err != nil {
+		ws.sendError(client, baseIncoming, newError(CodeValidationFailed, "invalid message: %v", err))
+		return
+	}
+
+	// Check if we have a handler for the incoming message type
+	handler, ok := ws.handlers[baseIncoming.Type]
+	if !ok {
+		ws.sendError(client, baseIncoming, newError(CodeUnknownType, "no handler for message type: %s", baseIncoming.Type))
+		return
+	}
+
+	result, err := handler(client, messageBytes)
+	if err != nil {
+		ws.sendError(client, baseIncoming, err)
+		return
+	}
+
+	ws.sendAck(client, baseIncoming, result)
+}
+
+// sendAck answers a successful request. Requests without a request_id are not
+// acknowledged, since the client could not tell the ack apart anyway.
+func (ws *WebSocketServer) sendAck(client *types.Client, incoming types.IncomingBase, result any) {
+	if incoming.RequestID == "" {
+		return
+	}
+
+	resultRaw, err := json.Marshal(result)
+	if err != nil {
+		log.Printf("Error marshaling ack result: %v", err)
+		return
+	}
+
+	ws.sendFrame(client, "ack", types.AckData{
+		RequestID: incoming.RequestID,
+		For:       incoming.Type,
+		Result:    resultRaw,
+	})
+}
+
+// sendError answers a failed request. Errors other than *Error are logged and
+// hidden from the client behind CodeInternal.
+func (ws *WebSocketServer) sendError(client *types.Client, incoming types.IncomingBase, err error) {
+	var wsErr *Error
+	if !errors.As(err, &wsErr) {
+		log.Printf("Error handling %s from user %d: %v", incoming.Type, client.UserID, err)
+		wsErr = newError(CodeInternal, "internal server error")
+	}
+
+	ws.sendFrame(client, "error", types.ErrorData{
+		RequestID: incoming.RequestID,
+		For:       incoming.Type,
+		Code:      wsErr.Code,
+		Message:   wsErr.Message,
+	})
+}
 
+// sendFrame sends a frame to a single client, bypassing the broadcast.
+func (ws *WebSocketServer) sendFrame(client *types.Client, frameType string, data any) {
+	dataRaw, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Error marshaling %s frame: %v", frameType, err)
+		return
+	}
+
+	finalRaw, err := json.Marshal(types.Final{Type: frameType, Data: dataRaw})
+	if err != nil {
+		log.Printf("Error marshaling %s frame: %v", frameType, err)
+		return
+	}
+
+	if !ws.hub.sendTo(client, types.Frame{Data: finalRaw}) {
+		log.Printf("Dropped %s frame for user %d", frameType, client.UserID)
 	}
 }
 
-func (ws *WebSocketServer) handleWrites(client *types.Client) {
+// handleWrites is the only goroutine writing to the connection. It exits, and
+// closes the connection, once the hub closes client.Send. Logged frames up to
+// skipThrough were already replayed and are skipped.
+func (ws *WebSocketServer) handleWrites(client *types.Client, skipThrough int64) {
+	writeWait := ws.config.WebSocket.WriteWait
+	ticker := time.NewTicker(ws.config.WebSocket.PingInterval)
 	defer func() {
-		delete(ws.clients, client)
+		ticker.Stop()
 		client.Conn.Close()
 	}()
 
-	for message := range client.Send {
-		err := client.Conn.WriteMessage(websocket.TextMessage, message)
-		if err != nil {
-			log.Printf("Error writing message to client: %v", err)
-			break
+	for {
+		select {
+		case <-client.Send.Ready():
+			for {
+				if client.Send.Closed() {
+					// The hub unregistered the client, say goodbye
+					client.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+					closeMsg := websocket.FormatCloseMessage(client.CloseCode, client.CloseReason)
+					client.Conn.WriteMessage(websocket.CloseMessage, closeMsg)
+					return
+				}
+
+				frame, ok := client.Send.Pop()
+				if !ok {
+					break
+				}
+				if frame.Cursor != 0 && frame.Cursor <= skipThrough {
+					continue
+				}
+
+				client.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+				if err := client.Conn.WriteMessage(websocket.TextMessage, frame.Data); err != nil {
+					ws.handleWriteError(client, err)
+					return
+				}
+			}
+
+		case <-ticker.C:
+			client.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := client.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				ws.handleWriteError(client, err)
+				return
+			}
+		}
+	}
+}
+
+// handleWriteError unregisters a client whose connection can no longer be written.
+func (ws *WebSocketServer) handleWriteError(client *types.Client, err error) {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		if ws.hub.unregister(client, websocket.CloseGoingAway, "write timeout") {
+			metrics.Add("reaped_connections", 1)
+			log.Printf("Reaped stalled connection of user %d", client.UserID)
 		}
+		return
 	}
+
+	log.Printf("Error writing message to client: %v", err)
+	ws.hub.unregister(client, websocket.CloseInternalServerErr, "write error")
 }
 
-func (ws *WebSocketServer) authenticateWebSocket(r *http.Request) (int, error) {
+func (ws *WebSocketServer) authenticateWebSocket(r *http.Request) (types.Session, error) {
 	sessionToken := r.Header.Get("session_token")
 
 	if sessionToken == "" {
-		return -1, fmt.Errorf("session token not found")
+		return types.Session{}, fmt.Errorf("session token not found")
 	}
 
-	isValid, userId, err := ws.storage.VerifySession(sessionToken)
-	if err != nil || userId == -1 || !isValid {
-		return -1, fmt.Errorf("failed to verify session: %w", err)
+	session, err := ws.storage.VerifySession(sessionToken)
+	if err != nil {
+		return types.Session{}, fmt.Errorf("failed to verify session: %w", err)
 	}
 
-	return userId, nil
+	return session, nil
 }
 
 func (ws *WebSocketServer) createUpgrader() websocket.Upgrader {
@@ -155,17 +340,44 @@ func (ws *WebSocketServer) createUpgrader() websocket.Upgrader {
 		WriteBufferSize: 1024,
 
 		CheckOrigin: func(r *http.Request) bool {
-			return r.Host == "localhost:3000"
+			return slices.Contains(ws.config.Server.AllowedOrigins, r.Host)
 		},
 	}
 
 	return upgrader
 }
 
-func (ws *WebSocketServer) BroadcastMessages() {
-	for {
-		msg := <-ws.broadcast
+// publishEvent marshals data and broadcasts it, through every instance, to verifyIDs.
+func (ws *WebSocketServer) publishEvent(eventType, verifyType string, verifyIDs []int, data any) error {
+	return ws.publishEventExcept(eventType, verifyType, verifyIDs, nil, data)
+}
 
+// publishEventExcept is publishEvent leaving the users in excludeIDs out.
+func (ws *WebSocketServer) publishEventExcept(eventType, verifyType string, verifyIDs, excludeIDs []int, data any) error {
+	dataRaw, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s data: %w", eventType, err)
+	}
+
+	outgoingMsg := types.OutgoingBase{
+		Type:       eventType,
+		Data:       dataRaw,
+		VerifyType: verifyType,
+		VerifyIDs:  verifyIDs,
+		ExcludeIDs: excludeIDs,
+	}
+	ws.logEvent(&outgoingMsg)
+
+	marshaledMsg, err := json.Marshal(outgoingMsg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s message: %w", eventType, err)
+	}
+
+	return ws.fanOut.Publish(marshaledMsg)
+}
+
+func (ws *WebSocketServer) BroadcastMessages() {
+	for msg := range ws.fanOut.Messages() {
 		// Unmarshal the message to get metadata like recipient ID
 		var outgoingMsg types.OutgoingBase
 		if err := json.Unmarshal(msg, &outgoingMsg); err != nil {
@@ -173,10 +385,31 @@ func (ws *WebSocketServer) BroadcastMessages() {
 			continue
 		}
 
+		// Events of other instances were missed, clients resume from the event log
+		if outgoingMsg.Type == types.EventFanOutGap {
+			ws.hub.closeAll(websocket.CloseTryAgainLater, "missed events, reconnect")
+			continue
+		}
+
+		// Membership changes must be applied before anything else is sent to the chat
+		if outgoingMsg.Type == "chatMembershipChanged" {
+			if err := ws.applyMembershipEvent(outgoingMsg); err != nil {
+				log.Printf("Error applying membership change: %v", err)
+			}
+		}
+
+		// Connections of revoked sessions are closed on every instance
+		if outgoingMsg.Type == "sessionsRevoked" {
+			if err := ws.applySessionsRevoked(outgoingMsg); err != nil {
+				log.Printf("Error closing revoked sessions: %v", err)
+			}
+		}
+
 		// Create final message that will be sent
 		final := types.Final{
-			Type: outgoingMsg.Type,
-			Data: outgoingMsg.Data,
+			Type:   outgoingMsg.Type,
+			Data:   outgoingMsg.Data,
+			Cursor: outgoingMsg.Cursor,
 		}
 		finalRaw, err := json.Marshal(final)
 		if err != nil {
@@ -184,35 +417,26 @@ func (ws *WebSocketServer) BroadcastMessages() {
 			continue
 		}
 
-		// Iterate through clients and send messages
-		for client := range ws.clients {
-			isUserTheReceiver := false
-			if outgoingMsg.VerifyType == "chatID" {
-				for _, id := range outgoingMsg.VerifyIDs {
-					if client.ChatIDs[id] {
-						isUserTheReceiver = true
-						break
-					}
-				}
-			}
+		// Send the message to the recipients only
+		frame := types.Frame{Data: finalRaw, Key: ws.coalesceKey(outgoingMsg), Cursor: outgoingMsg.Cursor}
+		for _, client := range ws.hub.deliver(outgoingMsg.VerifyType, outgoingMsg.VerifyIDs, outgoingMsg.ExcludeIDs, frame) {
+			log.Printf("Dropped slow client of user %d", client.UserID)
+		}
+	}
+}
 
-			if outgoingMsg.VerifyType == "userID" {
-				for _, id := range outgoingMsg.VerifyIDs {
-					if client.UserID == id {
-						isUserTheReceiver = true
-						break
-					}
-				}
-			}
+// coalesceKey returns the key under which frames of a coalescable event type
+// replace each other in client queues: one slot per event type, chat and user.
+func (ws *WebSocketServer) coalesceKey(outgoingMsg types.OutgoingBase) string {
+	if !slices.Contains(ws.config.WebSocket.CoalesceTypes, outgoingMsg.Type) {
+		return ""
+	}
 
-			if isUserTheReceiver {
-				select {
-				case client.Send <- finalRaw:
-				default:
-					close(client.Send)
-					delete(ws.clients, client)
-				}
-			}
-		}
+	var subject struct {
+		ChatID int `json:"chat_id"`
+		UserID int `json:"user_id"`
 	}
+	json.Unmarshal(outgoingMsg.Data, &subject)
+
+	return fmt.Sprintf("%s:%d:%d", outgoingMsg.Type, subject.ChatID, subject.UserID)
 }